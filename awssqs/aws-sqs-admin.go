@@ -0,0 +1,270 @@
+package awssqs
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// this is our admin interface implementation
+type awsSqsAdminImpl struct {
+	svc     sqsiface.SQSAPI
+	handles *queueHandleCache // shared with the SQS interface if there is one
+}
+
+// factory for our SQS admin interface
+func newAwsSqsAdmin() (AWS_SQS_ADMIN, error) {
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return &awsSqsAdminImpl{sqs.New(sess), newQueueHandleCache()}, nil
+}
+
+// factory for an SQS admin interface sharing the service of one of our SQS interfaces
+func newAwsSqsAdminFor(s AWS_SQS) (AWS_SQS_ADMIN, error) {
+
+	awsi, ok := s.(*awsSqsImpl)
+	if ok == false {
+		return nil, ErrMissingConfiguration
+	}
+
+	return &awsSqsAdminImpl{awsi.svc, awsi.handles}, nil
+}
+
+// CreateQueue create a queue, returning the queue handle
+func (admin *awsSqsAdminImpl) CreateQueue(ctx context.Context, queueName string, config QueueConfig) (QueueHandle, error) {
+
+	// SQS requires FIFO queue names to end with the suffix
+	if config.Fifo != strings.HasSuffix(queueName, fifoQueueSuffix) {
+		return "", ErrBadFifoQueueName
+	}
+
+	attributes := make(map[string]*string)
+	for k, v := range config.Attributes {
+		attributes[k] = aws.String(v)
+	}
+	if config.Fifo == true {
+		attributes[sqs.QueueAttributeNameFifoQueue] = aws.String("true")
+		attributes[sqs.QueueAttributeNameContentBasedDeduplication] = aws.String(strconv.FormatBool(config.ContentBasedDeduplication))
+	}
+	if config.VisibilityTimeout != 0 {
+		if config.VisibilityTimeout.Seconds() > float64(MAX_SQS_VISIBILITY_TIMEOUT) {
+			return "", ErrVisibilityTooLarge
+		}
+		attributes[sqs.QueueAttributeNameVisibilityTimeout] = aws.String(strconv.Itoa(int(config.VisibilityTimeout.Seconds())))
+	}
+
+	result, err := admin.svc.CreateQueueWithContext(ctx, &sqs.CreateQueueInput{
+		QueueName:  aws.String(queueName),
+		Attributes: attributes,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if strings.HasPrefix(err.Error(), sqs.ErrCodeQueueNameExists) {
+			return "", ErrQueueExists
+		}
+		return "", err
+	}
+
+	return QueueHandle(aws.StringValue(result.QueueUrl)), nil
+}
+
+// DeleteQueue delete a queue and any messages it contains
+func (admin *awsSqsAdminImpl) DeleteQueue(ctx context.Context, queue QueueHandle) error {
+
+	_, err := admin.svc.DeleteQueueWithContext(ctx, &sqs.DeleteQueueInput{
+		QueueUrl: aws.String(string(queue)),
+	})
+	if err == nil {
+		admin.handles.invalidate(queue)
+	}
+	return admin.mapError(ctx, err)
+}
+
+// PurgeQueue delete all the messages in a queue
+func (admin *awsSqsAdminImpl) PurgeQueue(ctx context.Context, queue QueueHandle) error {
+
+	_, err := admin.svc.PurgeQueueWithContext(ctx, &sqs.PurgeQueueInput{
+		QueueUrl: aws.String(string(queue)),
+	})
+	return admin.mapError(ctx, err)
+}
+
+// ListQueues list all the queues whose names begin with the prefix
+func (admin *awsSqsAdminImpl) ListQueues(ctx context.Context, prefix string) ([]QueueHandle, error) {
+
+	queues := make([]QueueHandle, 0)
+	token := ""
+	for {
+		page, next, err := admin.ListQueuesPage(ctx, prefix, 0, token)
+		if err != nil {
+			return nil, err
+		}
+		queues = append(queues, page...)
+		if len(next) == 0 {
+			return queues, nil
+		}
+		token = next
+	}
+}
+
+// ListQueuesPage list a page of the queues whose names begin with the prefix
+func (admin *awsSqsAdminImpl) ListQueuesPage(ctx context.Context, prefix string, maxResults uint, nextToken string) ([]QueueHandle, string, error) {
+
+	// SQS only paginates when a page size is supplied
+	if maxResults == 0 || maxResults > MAX_SQS_LIST_QUEUES_PAGE {
+		maxResults = MAX_SQS_LIST_QUEUES_PAGE
+	}
+
+	input := &sqs.ListQueuesInput{MaxResults: aws.Int64(int64(maxResults))}
+	if len(prefix) != 0 {
+		input.QueueNamePrefix = aws.String(prefix)
+	}
+	if len(nextToken) != 0 {
+		input.NextToken = aws.String(nextToken)
+	}
+
+	result, err := admin.svc.ListQueuesWithContext(ctx, input)
+	if err != nil {
+		return nil, "", admin.mapError(ctx, err)
+	}
+
+	queues := make([]QueueHandle, 0, len(result.QueueUrls))
+	for _, url := range result.QueueUrls {
+		queues = append(queues, QueueHandle(aws.StringValue(url)))
+	}
+	return queues, aws.StringValue(result.NextToken), nil
+}
+
+// GetQueueAttributes get the named attributes of a queue
+func (admin *awsSqsAdminImpl) GetQueueAttributes(ctx context.Context, queue QueueHandle, names ...string) (map[string]string, error) {
+
+	if len(names) == 0 {
+		names = []string{sqs.QueueAttributeNameAll}
+	}
+
+	result, err := admin.svc.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(string(queue)),
+		AttributeNames: aws.StringSlice(names),
+	})
+	if err != nil {
+		return nil, admin.mapError(ctx, err)
+	}
+
+	return aws.StringValueMap(result.Attributes), nil
+}
+
+// SetQueueAttributes set the supplied attributes of a queue
+func (admin *awsSqsAdminImpl) SetQueueAttributes(ctx context.Context, queue QueueHandle, attributes map[string]string) error {
+
+	_, err := admin.svc.SetQueueAttributesWithContext(ctx, &sqs.SetQueueAttributesInput{
+		QueueUrl:   aws.String(string(queue)),
+		Attributes: aws.StringMap(attributes),
+	})
+	return admin.mapError(ctx, err)
+}
+
+// SetRedrivePolicy set the dead letter queue configuration of a queue
+func (admin *awsSqsAdminImpl) SetRedrivePolicy(ctx context.Context, queue QueueHandle, policy RedrivePolicy) error {
+
+	// an empty policy removes the configuration
+	if len(policy.DeadLetterQueue) == 0 {
+		return admin.SetQueueAttributes(ctx, queue, map[string]string{sqs.QueueAttributeNameRedrivePolicy: ""})
+	}
+
+	if policy.MaxReceiveCount == 0 || policy.MaxReceiveCount > MAX_SQS_RECEIVE_COUNT {
+		return ErrBadMaxReceiveCount
+	}
+
+	// the policy refers to the dead letter queue by ARN
+	attributes, err := admin.GetQueueAttributes(ctx, policy.DeadLetterQueue, sqs.QueueAttributeNameQueueArn)
+	if err != nil {
+		return err
+	}
+
+	value := makeRedrivePolicy(attributes[sqs.QueueAttributeNameQueueArn], policy.MaxReceiveCount)
+	return admin.SetQueueAttributes(ctx, queue, map[string]string{sqs.QueueAttributeNameRedrivePolicy: value})
+}
+
+// GetRedrivePolicy get the dead letter queue configuration of a queue
+func (admin *awsSqsAdminImpl) GetRedrivePolicy(ctx context.Context, queue QueueHandle) (RedrivePolicy, error) {
+
+	attributes, err := admin.GetQueueAttributes(ctx, queue, sqs.QueueAttributeNameRedrivePolicy)
+	if err != nil {
+		return RedrivePolicy{}, err
+	}
+
+	value := attributes[sqs.QueueAttributeNameRedrivePolicy]
+	if len(value) == 0 {
+		return RedrivePolicy{}, nil
+	}
+	arn, maxReceiveCount, err := parseRedrivePolicy(value)
+	if err != nil {
+		return RedrivePolicy{}, err
+	}
+
+	// get the dead letter queue handle from the ARN (arn:aws:sqs:region:account:name)
+	input := &sqs.GetQueueUrlInput{QueueName: aws.String(queueNameFromArn(arn))}
+	tokens := strings.Split(arn, ":")
+	if len(tokens) == 6 {
+		input.QueueOwnerAWSAccountId = aws.String(tokens[4])
+	}
+	result, err := admin.svc.GetQueueUrlWithContext(ctx, input)
+	if err != nil {
+		return RedrivePolicy{}, admin.mapError(ctx, err)
+	}
+
+	return RedrivePolicy{DeadLetterQueue: QueueHandle(aws.StringValue(result.QueueUrl)), MaxReceiveCount: maxReceiveCount}, nil
+}
+
+// ListDeadLetterSourceQueues list the queues that use the specified queue as their dead letter queue
+func (admin *awsSqsAdminImpl) ListDeadLetterSourceQueues(ctx context.Context, deadLetterQueue QueueHandle) ([]QueueHandle, error) {
+
+	queues := make([]QueueHandle, 0)
+	input := &sqs.ListDeadLetterSourceQueuesInput{
+		QueueUrl:   aws.String(string(deadLetterQueue)),
+		MaxResults: aws.Int64(int64(MAX_SQS_LIST_QUEUES_PAGE)),
+	}
+	for {
+		result, err := admin.svc.ListDeadLetterSourceQueuesWithContext(ctx, input)
+		if err != nil {
+			return nil, admin.mapError(ctx, err)
+		}
+		for _, url := range result.QueueUrls {
+			queues = append(queues, QueueHandle(aws.StringValue(url)))
+		}
+		if len(aws.StringValue(result.NextToken)) == 0 {
+			return queues, nil
+		}
+		input.NextToken = result.NextToken
+	}
+}
+
+// map the errors we know about to our own errors
+func (admin *awsSqsAdminImpl) mapError(ctx context.Context, err error) error {
+
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if strings.HasPrefix(err.Error(), sqs.ErrCodeQueueDoesNotExist) {
+		return ErrBadQueueHandle
+	}
+	return err
+}
+
+//
+// end of file
+//
@@ -0,0 +1,146 @@
+package awssqs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+var adminQueuePrefix = "virgo4-ingest-test-admin-"
+
+//
+// AWS_SQS_ADMIN behavior tests
+//
+
+func TestAdminCreateListDelete(t *testing.T) {
+
+	awssqs := NewInMemorySqs()
+	admin, err := NewAwsSqsAdminFor(awssqs)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	ctx := context.Background()
+
+	standard, err := admin.CreateQueue(ctx, adminQueuePrefix+"standard", QueueConfig{VisibilityTimeout: time.Minute})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	fifo, err := admin.CreateQueue(ctx, adminQueuePrefix+"ordered.fifo", QueueConfig{Fifo: true, ContentBasedDeduplication: true})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	_, err = admin.CreateQueue(ctx, "virgo4-ingest-test-other", QueueConfig{})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// creating the same queue again is fine, with different attributes is not
+	again, err := admin.CreateQueue(ctx, adminQueuePrefix+"standard", QueueConfig{VisibilityTimeout: time.Minute})
+	if err != nil || again != standard {
+		t.Fatalf("%t\n", err)
+	}
+	_, err = admin.CreateQueue(ctx, adminQueuePrefix+"standard", QueueConfig{VisibilityTimeout: time.Hour})
+	if err != ErrQueueExists {
+		t.Fatalf("%t\n", err)
+	}
+	_, err = admin.CreateQueue(ctx, adminQueuePrefix+"unordered", QueueConfig{Fifo: true})
+	if err != ErrBadFifoQueueName {
+		t.Fatalf("%t\n", err)
+	}
+
+	// the new queues are usable
+	queueHandle, err := awssqs.QueueHandle(adminQueuePrefix + "ordered.fifo")
+	if err != nil || queueHandle != fifo {
+		t.Fatalf("%t\n", err)
+	}
+
+	// list a page at a time
+	first, token, err := admin.ListQueuesPage(ctx, adminQueuePrefix, 1, "")
+	if err != nil || len(first) != 1 || first[0] != fifo || len(token) == 0 {
+		t.Fatalf("Unexpected first page %v (%t)\n", first, err)
+	}
+	second, token, err := admin.ListQueuesPage(ctx, adminQueuePrefix, 1, token)
+	if err != nil || len(second) != 1 || second[0] != standard || len(token) != 0 {
+		t.Fatalf("Unexpected second page %v (%t)\n", second, err)
+	}
+	all, err := admin.ListQueues(ctx, "")
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Unexpected queue list %v (%t)\n", all, err)
+	}
+
+	err = admin.DeleteQueue(ctx, standard)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	_, err = awssqs.QueueHandle(adminQueuePrefix + "standard")
+	if err != ErrBadQueueName {
+		t.Fatalf("%t\n", err)
+	}
+	err = admin.DeleteQueue(ctx, standard)
+	if err != ErrBadQueueHandle {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+func TestAdminPurgeQueue(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	admin, _ := NewAwsSqsAdminFor(awssqs)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeStandardMessages(MAX_SQS_BLOCK_COUNT))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	err = admin.PurgeQueue(context.Background(), queueHandle)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	available, _ := awssqs.GetMessagesAvailable(inMemoryQueueName)
+	if available != 0 {
+		t.Fatalf("Expected no messages available, found %d\n", available)
+	}
+}
+
+func TestAdminQueueAttributes(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	admin, _ := NewAwsSqsAdminFor(awssqs)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	ctx := context.Background()
+
+	err := admin.SetQueueAttributes(ctx, queueHandle, map[string]string{
+		sqs.QueueAttributeNameVisibilityTimeout:      "120",
+		sqs.QueueAttributeNameMessageRetentionPeriod: "3600",
+	})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	attributes, err := admin.GetQueueAttributes(ctx, queueHandle, sqs.QueueAttributeNameVisibilityTimeout, sqs.QueueAttributeNameMessageRetentionPeriod)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(attributes) != 2 || attributes[sqs.QueueAttributeNameVisibilityTimeout] != "120" ||
+		attributes[sqs.QueueAttributeNameMessageRetentionPeriod] != "3600" {
+		t.Fatalf("Unexpected attributes %v\n", attributes)
+	}
+
+	// standard queues cannot use content based deduplication
+	err = admin.SetQueueAttributes(ctx, queueHandle, map[string]string{sqs.QueueAttributeNameContentBasedDeduplication: "true"})
+	if err == nil {
+		t.Fatalf("Expected an error setting an invalid attribute\n")
+	}
+
+	_, err = admin.GetQueueAttributes(ctx, badQueueHandle)
+	if err != ErrBadQueueHandle {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+//
+// end of file
+//
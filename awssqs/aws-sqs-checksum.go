@@ -0,0 +1,84 @@
+package awssqs
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// oversize messages sent by earlier versions have this attribute, the MD5 digest of the stored payload. The
+// digest is now sent in the payload pointer so it does not use one of the message attributes
+var oversizeChecksumAttributeName = "SQSLargePayloadMD5"
+
+// the transport types SQS uses when calculating the digest of message attributes
+var stringTransportType = byte(1)
+var binaryTransportType = byte(2)
+
+// the MD5 digest of some content as SQS reports it
+func md5Hex(content []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(content))
+}
+
+// the MD5 digest of a set of message attributes the way SQS calculates it. The attributes are taken in name
+// order and the name, data type and value of each is included, each preceded by its length
+func attributesMD5(attribs map[string]*sqs.MessageAttributeValue) string {
+
+	names := make([]string, 0, len(attribs))
+	for name := range attribs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := make([]byte, 0)
+	appendField := func(field []byte) {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(field)))
+		buf = append(buf, field...)
+	}
+	for _, name := range names {
+		v := attribs[name]
+		dataType := aws.StringValue(v.DataType)
+		appendField([]byte(name))
+		appendField([]byte(dataType))
+		if strings.HasPrefix(dataType, AttributeDataTypeBinary) == true {
+			buf = append(buf, binaryTransportType)
+			appendField(v.BinaryValue)
+		} else {
+			buf = append(buf, stringTransportType)
+			appendField([]byte(aws.StringValue(v.StringValue)))
+		}
+	}
+	return md5Hex(buf)
+}
+
+// verify the body and attribute digests reported with a received message, digests that are not
+// reported are not verified
+func verifyReceivedChecksums(awsMessage sqs.Message) error {
+	return verifyChecksums(aws.StringValue(awsMessage.Body), awsMessage.MessageAttributes,
+		aws.StringValue(awsMessage.MD5OfBody), aws.StringValue(awsMessage.MD5OfMessageAttributes))
+}
+
+// verify the body and attribute digests reported for a sent message match what was sent
+func verifySendChecksums(sent *sqs.SendMessageBatchRequestEntry, result *sqs.SendMessageBatchResultEntry) error {
+	return verifyChecksums(aws.StringValue(sent.MessageBody), sent.MessageAttributes,
+		aws.StringValue(result.MD5OfMessageBody), aws.StringValue(result.MD5OfMessageAttributes))
+}
+
+func verifyChecksums(body string, attribs map[string]*sqs.MessageAttributeValue, bodyMD5 string, attribsMD5 string) error {
+
+	if len(bodyMD5) != 0 && md5Hex([]byte(body)) != bodyMD5 {
+		return ErrChecksumMismatch
+	}
+	if len(attribsMD5) != 0 && attributesMD5(attribs) != attribsMD5 {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+//
+// end of file
+//
@@ -0,0 +1,161 @@
+package awssqs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+//
+// MD5 checksum behavior tests
+//
+
+func TestSendChecksumMismatch(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	backend := inMemoryBackend(awssqs)
+	svc := &corruptingSqsService{SQSAPI: backend.svc, corruptSends: 1}
+	backend.svc = svc
+	backend.retry = testRetryPolicy
+	store := backend.store.(*memoryPayloadStore)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	results, err := awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, append(makeLargeMessages(1), makeSmallMessage()))
+	if err != ErrOneOrMoreOperationsUnsuccessful {
+		t.Fatalf("%t\n", err)
+	}
+	if results[0].Code != BatchEntryCodeChecksumMismatch || results[0].Retryable == true || results[1].Success == false {
+		t.Fatalf("Expected the first message alone to fail, got %+v\n", results)
+	}
+
+	// the mismatched message was enqueued so it is not sent again and its payload is kept
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil || len(received) != 2 || store.count() != 1 {
+		t.Fatalf("Expected the mismatched message to be enqueued once with its payload, got %d (%t)\n", len(received), err)
+	}
+	verifyMessages(t, received)
+}
+
+func TestReceiveChecksumMismatch(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	backend := inMemoryBackend(awssqs)
+	svc := &corruptingSqsService{SQSAPI: backend.svc}
+	backend.svc = svc
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeSmallMessages(2))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// one of the received messages is corrupt
+	svc.corruptReceives = 1
+	messages, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != ErrChecksumMismatch {
+		t.Fatalf("%t\n", err)
+	}
+	if len(messages) != 2 || messages[0].Incomplete == false || messages[1].Incomplete == true {
+		t.Fatalf("Expected the corrupt message alone to be incomplete\n")
+	}
+	verifyMessages(t, messages[1:])
+}
+
+func TestOversizeChecksumMismatch(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeLargeMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// corrupt the stored payload without changing its size
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	for _, payload := range store.payloads {
+		payload[0] ^= 0xff
+	}
+
+	messages, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != ErrChecksumMismatch || len(messages) != 1 || messages[0].Incomplete == false {
+		t.Fatalf("Expected the oversize message to be incomplete (%t)\n", err)
+	}
+}
+
+func TestOversizeChecksumInPointer(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeLargeMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// the payload checksum does not use one of the message attributes
+	sent := inMemoryQueueFor(awssqs, queueHandle).messages[0]
+	if sent.attributes[oversizeChecksumAttributeName] != nil || strings.Contains(sent.body, s3ChecksumMapKeyValue) == false {
+		t.Fatalf("Expected the payload checksum in the payload pointer %s\n", sent.body)
+	}
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil || len(received) != 1 {
+		t.Fatalf("Expected 1 message (%t)\n", err)
+	}
+	verifyMessages(t, received)
+
+	// the checksum attribute of messages sent by earlier versions is still verified
+	message := makeLargeMessage()
+	err = message.convertToOversizeMessage(context.Background(), inMemoryBackend(awssqs).store, inMemoryMessageBucketName, "legacy", PointerFormatMessageS3Pointer)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	message.Payload = message.encodeS3MarkerInformation(inMemoryMessageBucketName, "legacy", "", PointerFormatMessageS3Pointer)
+	message.addAttribute(oversizeChecksumAttributeName, md5Hex([]byte("something else")))
+	_, err = awssqs.BatchMessagePut(queueHandle, []Message{message})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	received, err = awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != ErrChecksumMismatch || len(received) != 1 || received[0].Incomplete == false {
+		t.Fatalf("Expected the legacy message to be incomplete (%t)\n", err)
+	}
+}
+
+//
+// helper methods
+//
+
+// an SQS service that reports the wrong checksums for a number of sent and received messages
+type corruptingSqsService struct {
+	sqsiface.SQSAPI
+	corruptSends    int
+	corruptReceives int
+}
+
+func (c *corruptingSqsService) SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	output, err := c.SQSAPI.SendMessageBatchWithContext(ctx, input, opts...)
+	for ix := 0; err == nil && ix < len(output.Successful) && c.corruptSends != 0; ix++ {
+		output.Successful[ix].MD5OfMessageBody = aws.String(md5Hex([]byte("corrupt")))
+		c.corruptSends--
+	}
+	return output, err
+}
+
+func (c *corruptingSqsService) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	output, err := c.SQSAPI.ReceiveMessageWithContext(ctx, input, opts...)
+	for ix := 0; err == nil && ix < len(output.Messages) && c.corruptReceives != 0; ix++ {
+		output.Messages[ix].Body = aws.String("corrupt")
+		c.corruptReceives--
+	}
+	return output, err
+}
+
+//
+// end of file
+//
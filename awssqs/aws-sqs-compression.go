@@ -0,0 +1,121 @@
+package awssqs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressed messages have this attribute, its value is the compression used
+var compressionAttributeName = "SQSPayloadCompression"
+
+// the largest payload we will decompress, protects against payloads that decompress to something huge
+var maxDecompressedPayloadSize = 64 * 1024 * 1024
+
+var errDecompressedPayloadTooLarge = fmt.Errorf("decompressed payload is too large")
+
+// the zstd encoder and decoder are safe for concurrent use so we share them
+var zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil)
+})
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(maxDecompressedPayloadSize)))
+})
+
+// is the compression one we support
+func validCompression(compression PayloadCompression) bool {
+	return compression == CompressionNone || compression == CompressionGzip || compression == CompressionZstd
+}
+
+// is the message payload compressed
+func (m *Message) isCompressed() bool {
+	_, found := m.GetAttribute(compressionAttributeName)
+	return found
+}
+
+// compress the payload of a message that is too large to send, returns true (and replaces the payload) if the
+// compressed message is no larger than the limit. Message bodies must be text so the compressed payload is base64 encoded
+func (m *Message) compress(compression PayloadCompression, limit uint) (bool, error) {
+
+	compressed, err := compressPayload(compression, m.Payload)
+	if err != nil {
+		return false, err
+	}
+
+	candidate := Message{Attribs: append(Attributes{}, m.Attribs...), Payload: []byte(base64.StdEncoding.EncodeToString(compressed))}
+	candidate.addAttribute(compressionAttributeName, string(compression))
+	if candidate.Size() > limit {
+		return false, nil
+	}
+
+	m.Attribs = candidate.Attribs
+	m.Payload = candidate.Payload
+	return true, nil
+}
+
+// compress a payload
+func compressPayload(compression PayloadCompression, payload []byte) ([]byte, error) {
+
+	switch compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(payload)
+		if err == nil {
+			err = w.Close()
+		}
+		return buf.Bytes(), err
+
+	case CompressionZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(payload, nil), nil
+	}
+
+	return nil, ErrBadCompression
+}
+
+// decode and decompress a payload
+func decompressPayload(compression PayloadCompression, encoded []byte) ([]byte, error) {
+
+	compressed, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	switch compression {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		payload, err := io.ReadAll(io.LimitReader(r, int64(maxDecompressedPayloadSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) > maxDecompressedPayloadSize {
+			return nil, errDecompressedPayloadTooLarge
+		}
+		return payload, nil
+
+	case CompressionZstd:
+		decoder, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return decoder.DecodeAll(compressed, nil)
+	}
+
+	return nil, ErrBadCompression
+}
+
+//
+// end of file
+//
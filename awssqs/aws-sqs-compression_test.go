@@ -0,0 +1,99 @@
+package awssqs
+
+import (
+	"bytes"
+	"testing"
+)
+
+//
+// payload compression behavior tests
+//
+
+func TestCompressedMessages(t *testing.T) {
+
+	for _, compression := range []PayloadCompression{CompressionGzip, CompressionZstd} {
+
+		awssqs := NewInMemorySqs(inMemoryQueueName)
+		store := newMemoryPayloadStore()
+		inMemoryBackend(awssqs).store = store
+		inMemoryBackend(awssqs).config.Compression = compression
+		queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+		// a compressible oversize message is sent inline, an incompressible one is stored
+		compressible := Message{Payload: bytes.Repeat([]byte("<record><id>u123</id><title>A title</title></record>"), 10000)}
+		messages := []Message{compressible, makeLargeMessage()}
+		expected := [][]byte{compressible.Payload, messages[1].Payload}
+		_, err := awssqs.BatchMessagePut(queueHandle, messages)
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+		if len(store.payloads) != 1 {
+			t.Fatalf("Expected only the incompressible payload to be stored (%s), got %d\n", compression, len(store.payloads))
+		}
+
+		received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+		if len(received) != 2 {
+			t.Fatalf("Expected 2 messages, got %d\n", len(received))
+		}
+		for ix, m := range received {
+			if bytes.Equal(m.Payload, expected[ix]) == false {
+				t.Fatalf("Unexpected message payload (%s)\n", compression)
+			}
+			if _, found := m.GetAttribute(compressionAttributeName); found == true {
+				t.Fatalf("Expected the compression attribute to be removed\n")
+			}
+		}
+		if received[0].IsOversize() == true || received[1].IsOversize() == false {
+			t.Fatalf("Expected only the incompressible message to be oversize (%s)\n", compression)
+		}
+	}
+}
+
+func TestCompressedMessageNotValid(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	message := makeStandardMessage()
+	message.addAttribute(compressionAttributeName, string(CompressionGzip))
+	_, err := awssqs.BatchMessagePut(queueHandle, []Message{message})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err == nil || len(received) != 1 || received[0].Incomplete == false {
+		t.Fatalf("Expected an incomplete message\n")
+	}
+
+	_, err = NewAwsSqs(AwsSqsConfig{MessageBucketName: inMemoryMessageBucketName, Compression: "lz4"})
+	if err != ErrBadCompression {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+
+	payload := bytes.Repeat([]byte("compress me "), 1000)
+	for _, compression := range []PayloadCompression{CompressionGzip, CompressionZstd} {
+		m := Message{Payload: append([]byte(nil), payload...)}
+		fits, err := m.compress(compression, MAX_SQS_MESSAGE_SIZE)
+		if err != nil || fits == false {
+			t.Fatalf("Expected the payload to compress (%s), got %v\n", compression, err)
+		}
+		decompressed, err := decompressPayload(compression, m.Payload)
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+		if bytes.Equal(decompressed, payload) == false {
+			t.Fatalf("Unexpected decompressed payload (%s)\n", compression)
+		}
+	}
+}
+
+//
+// end of file
+//
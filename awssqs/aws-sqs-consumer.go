@@ -0,0 +1,248 @@
+package awssqs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// how long the consumer waits before polling again after a receive error
+var consumerErrorBackoff = 1 * time.Second
+
+// the default interval at which successfully processed messages are deleted
+var consumerDefaultDeleteInterval = 1 * time.Second
+
+// MessageHandler process a single message, returning nil if the message was processed successfully.
+// Messages that are not processed successfully are not deleted and so reappear on the queue once
+// their visibility timeout expires.
+type MessageHandler func(Message) error
+
+// ConsumerConfig our consumer configuration structure
+type ConsumerConfig struct {
+	Queue          QueueHandle    // the queue to consume from
+	Handler        MessageHandler // called for each message received
+	Concurrency    uint           // the number of messages processed concurrently (default 1)
+	BlockCount     uint           // the maximum number of messages received at a time (default MAX_SQS_BLOCK_COUNT)
+	WaitTime       time.Duration  // the long poll wait time (default MAX_SQS_WAIT_TIME)
+	DeleteInterval time.Duration  // the longest a processed message waits to be deleted (default 1 second)
+
+	// if specified, the visibility timeout of each message is repeatedly extended by this amount
+	// for as long as its handler runs
+	HeartbeatTimeout time.Duration
+
+	// incomplete messages (their payload could not be read) are dispatched to the handler and deleted if it
+	// succeeds. Otherwise they are not dispatched and reappear on the queue once their visibility timeout
+	// expires, eventually moving to any dead letter queue
+	HandleIncomplete bool
+}
+
+type Consumer interface {
+
+	// Run poll the queue and dispatch messages to the handler until the context is cancelled or an
+	// unrecoverable error occurs. On return, all handlers have completed and all successfully
+	// processed messages have been deleted. Cancelling the context is not an error.
+	Run(ctx context.Context) error
+}
+
+// NewConsumer factory for our managed consumer
+func NewConsumer(sqs AWS_SQS, config ConsumerConfig) (Consumer, error) {
+
+	// validate the inbound configuration
+	if sqs == nil || len(config.Queue) == 0 || config.Handler == nil {
+		return nil, ErrMissingConfiguration
+	}
+	csqs, ok := sqs.(AWS_SQS_Context)
+	if ok == false {
+		return nil, ErrContextNotSupported
+	}
+	if config.BlockCount > MAX_SQS_BLOCK_COUNT {
+		return nil, ErrBlockCountTooLarge
+	}
+	if config.WaitTime.Seconds() > float64(MAX_SQS_WAIT_TIME) {
+		return nil, ErrWaitTooLarge
+	}
+	if config.HeartbeatTimeout.Seconds() > float64(MAX_SQS_VISIBILITY_TIMEOUT) {
+		return nil, ErrVisibilityTooLarge
+	}
+	if config.HeartbeatTimeout != 0 && config.HeartbeatTimeout < time.Second {
+		return nil, ErrVisibilityTooSmall
+	}
+
+	// apply the defaults
+	if config.Concurrency == 0 {
+		config.Concurrency = 1
+	}
+	if config.BlockCount == 0 {
+		config.BlockCount = MAX_SQS_BLOCK_COUNT
+	}
+	if config.WaitTime == 0 {
+		config.WaitTime = time.Duration(MAX_SQS_WAIT_TIME) * time.Second
+	}
+	if config.DeleteInterval == 0 {
+		config.DeleteInterval = consumerDefaultDeleteInterval
+	}
+
+	return &consumerImpl{config: config, sqs: csqs, log: loggerFor(sqs)}, nil
+}
+
+// this is our consumer implementation, messages are received on one goroutine and handled on others
+type consumerImpl struct {
+	config ConsumerConfig
+	sqs    AWS_SQS_Context
+	log    Logger
+}
+
+// Run poll the queue and dispatch messages to the handler until the context is cancelled
+func (c *consumerImpl) Run(ctx context.Context) error {
+
+	// successfully processed messages are sent to the deleter
+	processed := make(chan Message, c.config.BlockCount)
+	deleterDone := make(chan struct{})
+	go func() {
+		c.deleter(processed)
+		close(deleterDone)
+	}()
+
+	// limits the number of concurrent handlers
+	slots := make(chan struct{}, c.config.Concurrency)
+	var handlers sync.WaitGroup
+
+	err := c.poll(ctx, func(message Message) {
+		slots <- struct{}{}
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			defer func() { <-slots }()
+			if c.handle(message) == nil {
+				processed <- message
+			}
+		}()
+	})
+
+	// wait for the outstanding handlers then for the final deletes
+	handlers.Wait()
+	close(processed)
+	<-deleterDone
+
+	return err
+}
+
+//
+// implementation methods
+//
+
+// receive messages and dispatch them until the context is cancelled or an unrecoverable error occurs
+func (c *consumerImpl) poll(ctx context.Context, dispatch func(Message)) error {
+
+	for {
+		messages, err := c.sqs.BatchMessageGetWithContext(ctx, c.config.Queue, c.config.BlockCount, c.config.WaitTime)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err == ErrBadQueueHandle {
+			c.log.Error("cannot receive", "queue", c.config.Queue, "error", err)
+			return err
+		}
+
+		// errors associated with incomplete messages are returned along with those messages, otherwise
+		// back off and try again
+		if err != nil && len(messages) == 0 {
+			c.log.Warn("receive error, retrying", "queue", c.config.Queue, "error", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(consumerErrorBackoff):
+			}
+			continue
+		}
+
+		for _, m := range messages {
+			if m.Incomplete == true && c.config.HandleIncomplete == false {
+				c.log.Warn("incomplete message, leaving it for redelivery", "queue", c.config.Queue)
+				continue
+			}
+			dispatch(m)
+		}
+	}
+}
+
+// call the handler, a handler that panics has failed to process the message
+func (c *consumerImpl) handle(message Message) (err error) {
+
+	// keep the message invisible while the handler runs if necessary, without that another consumer
+	// could receive it while it is handled so it is not dispatched
+	if c.config.HeartbeatTimeout != 0 {
+		hb, hbErr := NewVisibilityHeartbeat(c.sqs, c.config.Queue, []Message{message}, c.config.HeartbeatTimeout)
+		if hbErr != nil {
+			c.log.Error("cannot start visibility heartbeat", "queue", c.config.Queue, "error", hbErr)
+			return hbErr
+		}
+		defer hb.Stop()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.log.Error("message handler panic", "panic", r)
+			err = fmt.Errorf("message handler panic: %v", r)
+		}
+	}()
+
+	return c.config.Handler(message)
+}
+
+// delete processed messages in blocks, a block is deleted when it is full or when the delete
+// interval expires. Returns once the channel is closed and any remaining messages are deleted
+func (c *consumerImpl) deleter(processed <-chan Message) {
+
+	block := make([]Message, 0, MAX_SQS_BLOCK_COUNT)
+	ticker := time.NewTicker(c.config.DeleteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m, ok := <-processed:
+			if ok == false {
+				c.deleteBlock(block)
+				return
+			}
+			block = append(block, m)
+			if uint(len(block)) == MAX_SQS_BLOCK_COUNT {
+				c.deleteBlock(block)
+				block = block[:0]
+			}
+
+		case <-ticker.C:
+			c.deleteBlock(block)
+			block = block[:0]
+		}
+	}
+}
+
+// delete a block of messages, the messages that cannot be deleted will reappear on the queue. We do
+// not use the consumer context so processed messages are still deleted after it is cancelled
+func (c *consumerImpl) deleteBlock(block []Message) {
+
+	if len(block) == 0 {
+		return
+	}
+
+	ops, err := c.sqs.BatchMessageDelete(c.config.Queue, block)
+	if err != nil {
+		failed := len(block)
+		if len(ops) == len(block) {
+			failed = 0
+			for _, op := range ops {
+				if op == false {
+					failed++
+				}
+			}
+		}
+		c.log.Warn("delete error", "queue", c.config.Queue, "failed", failed, "total", len(block), "error", err)
+	}
+}
+
+//
+// end of file
+//
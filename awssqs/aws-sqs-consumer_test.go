@@ -0,0 +1,278 @@
+package awssqs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var consumerWaitTime = 1 * time.Second
+var consumerDeleteInterval = 50 * time.Millisecond
+
+//
+// Consumer behavior tests
+//
+
+func TestConsumerHappyDay(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+
+	count := 25
+	putNumberedMessages(t, awssqs, queueHandle, count)
+
+	var mu sync.Mutex
+	handled := make(map[string]int)
+	active, maxActive := 0, 0
+	concurrency := uint(4)
+
+	handler := func(m Message) error {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		active--
+		id, _ := m.GetAttribute(AttributeKeyRecordId)
+		handled[id]++
+		return nil
+	}
+
+	runConsumerUntil(t, awssqs, ConsumerConfig{Queue: queueHandle, Handler: handler, Concurrency: concurrency}, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == count
+	})
+
+	if uint(maxActive) > concurrency {
+		t.Fatalf("Too many concurrent handlers. Expected %d or less, got %d\n", concurrency, maxActive)
+	}
+
+	// the processed messages are deleted so do not reappear once their visibility timeout expires
+	clock.advance(inMemoryDefaultVisibilityTimeout)
+	available, _ := awssqs.GetMessagesAvailable(inMemoryQueueName)
+	if available != 0 {
+		t.Fatalf("Expected no messages available, found %d\n", available)
+	}
+}
+
+func TestConsumerFailuresReappear(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+
+	count := 5
+	putNumberedMessages(t, awssqs, queueHandle, count)
+
+	var mu sync.Mutex
+	handled := 0
+	handler := func(m Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled++
+		if handled == 1 {
+			panic("handler failure")
+		}
+		if handled == 2 {
+			return fmt.Errorf("handler failure")
+		}
+		return nil
+	}
+
+	runConsumerUntil(t, awssqs, ConsumerConfig{Queue: queueHandle, Handler: handler}, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return handled == count
+	})
+
+	clock.advance(inMemoryDefaultVisibilityTimeout)
+	available, _ := awssqs.GetMessagesAvailable(inMemoryQueueName)
+	if available != 2 {
+		t.Fatalf("Expected 2 messages available, found %d\n", available)
+	}
+}
+
+func TestConsumerIncompleteMessages(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+
+	// the payload of the oversize message cannot be read
+	_, err := awssqs.BatchMessagePut(queueHandle, append(makeLargeMessages(1), makeStandardMessages(1)...))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	inMemoryBackend(awssqs).store = failingPayloadStore{}
+
+	var mu sync.Mutex
+	handled, incomplete := 0, 0
+	handler := func(m Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled++
+		if m.Incomplete == true {
+			incomplete++
+		}
+		return nil
+	}
+
+	// incomplete messages are left for redelivery
+	runConsumerUntil(t, awssqs, ConsumerConfig{Queue: queueHandle, Handler: handler}, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return handled == 1
+	})
+	clock.advance(inMemoryDefaultVisibilityTimeout)
+	available, _ := awssqs.GetMessagesAvailable(inMemoryQueueName)
+	if incomplete != 0 || available != 1 {
+		t.Fatalf("Expected the incomplete message to be left on the queue, found %d\n", available)
+	}
+
+	// unless the handler is configured to handle them
+	runConsumerUntil(t, awssqs, ConsumerConfig{Queue: queueHandle, Handler: handler, HandleIncomplete: true}, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return handled == 2
+	})
+	if incomplete != 1 {
+		t.Fatalf("Expected the incomplete message to be handled\n")
+	}
+}
+
+func TestConsumerHeartbeatFailure(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// a message whose visibility cannot be kept up is not handled
+	handled := false
+	consumer := &consumerImpl{config: ConsumerConfig{Queue: queueHandle, HeartbeatTimeout: time.Millisecond,
+		Handler: func(Message) error { handled = true; return nil }}, sqs: awssqs, log: loggerFor(awssqs)}
+	err := consumer.handle(makeStandardMessage())
+	if err != ErrVisibilityTooSmall || handled == true {
+		t.Fatalf("Expected the message not to be handled (%t)\n", err)
+	}
+}
+
+func TestConsumerBadQueueHandle(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	consumer, err := NewConsumer(awssqs, ConsumerConfig{Queue: badQueueHandle, Handler: func(Message) error { return nil }})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	err = consumer.Run(context.Background())
+	if err != ErrBadQueueHandle {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+func TestConsumerBadConfiguration(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := NewConsumer(awssqs, ConsumerConfig{Queue: queueHandle})
+	if err != ErrMissingConfiguration {
+		t.Fatalf("%t\n", err)
+	}
+
+	_, err = NewConsumer(awssqs, ConsumerConfig{Queue: queueHandle, Handler: func(Message) error { return nil }, BlockCount: MAX_SQS_BLOCK_COUNT + 1})
+	if err != ErrBlockCountTooLarge {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+func TestContextNotSupported(t *testing.T) {
+
+	// an AWS_SQS implementation without the context operations
+	awssqs := struct{ AWS_SQS }{NewInMemorySqs(inMemoryQueueName)}
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := NewConsumer(awssqs, ConsumerConfig{Queue: queueHandle, Handler: func(Message) error { return nil }})
+	if err != ErrContextNotSupported {
+		t.Fatalf("%t\n", err)
+	}
+	_, err = NewProducer(awssqs, ProducerConfig{Queue: queueHandle})
+	if err != ErrContextNotSupported {
+		t.Fatalf("%t\n", err)
+	}
+	_, err = NewVisibilityHeartbeat(awssqs, queueHandle, makeStandardMessages(1), time.Minute)
+	if err != ErrContextNotSupported {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+//
+// helper methods
+//
+
+// put the specified number of messages, each with a unique id attribute
+func putNumberedMessages(t *testing.T, awssqs AWS_SQS, queue QueueHandle, count int) {
+
+	messages := make([]Message, 0, MAX_SQS_BLOCK_COUNT)
+	for ix := 0; ix < count; ix++ {
+		m := makeStandardMessage()
+		m.Attribs = append(m.Attribs, Attribute{Name: AttributeKeyRecordId, Value: fmt.Sprintf("%d", ix)})
+		messages = append(messages, m)
+		if uint(len(messages)) == MAX_SQS_BLOCK_COUNT || ix == count-1 {
+			ops, err := awssqs.BatchMessagePut(queue, messages)
+			if err != nil {
+				t.Fatalf("%t\n", err)
+			}
+			if allOperationsOK(ops) == false {
+				t.Fatalf("One or more put operations reported failed incorrectly\n")
+			}
+			messages = messages[:0]
+		}
+	}
+}
+
+// run the consumer until the condition is satisfied, the condition must be satisfied within the wait time
+func runConsumerUntil(t *testing.T, awssqs AWS_SQS, config ConsumerConfig, condition func() bool) {
+
+	config.WaitTime = consumerWaitTime
+	config.DeleteInterval = consumerDeleteInterval
+	consumer, err := NewConsumer(awssqs, config)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- consumer.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(goodWaitTime)
+	for condition() == false {
+		if time.Now().After(deadline) {
+			cancel()
+			<-done
+			t.Fatalf("Consumer did not finish within %s\n", goodWaitTime)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,112 @@
+package awssqs
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// encrypted messages have these attributes, the id of the key that wrapped the data key and the wrapped data key
+var encryptionKeyIdAttributeName = "SQSEncryptionKeyId"
+var encryptedDataKeyAttributeName = "SQSEncryptedDataKey"
+
+var errSealedTooShort = fmt.Errorf("encrypted content is too short")
+
+// a data key and how it is sent with the messages it encrypted
+type envelopeKey struct {
+	key        []byte
+	wrappedKey []byte
+	keyId      string
+}
+
+// make a new envelope key using the key provider
+func newEnvelopeKey(ctx context.Context, keys KeyProvider) (*envelopeKey, error) {
+	key, wrappedKey, keyId, err := keys.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &envelopeKey{key: key, wrappedKey: wrappedKey, keyId: keyId}, nil
+}
+
+// is the message payload encrypted
+func (m *Message) isEncrypted() bool {
+	_, found := m.GetAttribute(encryptionKeyIdAttributeName)
+	return found
+}
+
+// encrypt the payload using the envelope key. Message bodies must be text so the encrypted payload is base64 encoded
+func (m *Message) encrypt(envelope *envelopeKey) error {
+
+	sealed, err := seal(envelope.key, m.Payload, []byte(envelope.keyId))
+	if err != nil {
+		return err
+	}
+
+	m.Payload = []byte(base64.StdEncoding.EncodeToString(sealed))
+	m.addAttribute(encryptionKeyIdAttributeName, envelope.keyId)
+	m.deleteAttribute(encryptedDataKeyAttributeName)
+	m.Attribs = append(m.Attribs, Attribute{Name: encryptedDataKeyAttributeName, DataType: AttributeDataTypeBinary, BinaryValue: envelope.wrappedKey})
+	return nil
+}
+
+// decrypt an encrypted payload using the data key wrapped by the specified key
+func decryptPayload(ctx context.Context, keys KeyProvider, keyId string, wrappedKey []byte, encoded []byte) ([]byte, error) {
+
+	if keys == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := keys.UnwrapDataKey(ctx, keyId, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, sealed, []byte(keyId))
+}
+
+// encrypt and authenticate using AES-GCM, the result is the nonce followed by the ciphertext
+func seal(key []byte, plaintext []byte, additional []byte) ([]byte, error) {
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// authenticate and decrypt the result of seal
+func open(key []byte, sealed []byte, additional []byte) ([]byte, error) {
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errSealedTooShort
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//
+// end of file
+//
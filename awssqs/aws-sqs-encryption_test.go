@@ -0,0 +1,280 @@
+package awssqs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+//
+// payload encryption behavior tests
+//
+
+func TestEncryptedMessages(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	inMemoryBackend(awssqs).config.KeyProvider = makeKeyProvider(t, "key-1")
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	messages := append(makeSmallMessages(1), makeLargeMessages(1)...)
+	expected := [][]byte{messages[0].Payload, messages[1].Payload}
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// neither the queue nor the payload store has the plaintext
+	for _, m := range inMemoryQueueFor(awssqs, queueHandle).messages {
+		if strings.Contains(m.body, string(expected[0])) == true {
+			t.Fatalf("Expected the message body to be encrypted\n")
+		}
+	}
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	for _, payload := range store.payloads {
+		if bytes.Equal(payload, expected[1]) == true {
+			t.Fatalf("Expected the stored payload to be encrypted\n")
+		}
+	}
+
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 messages, got %d\n", len(received))
+	}
+	for ix, m := range received {
+		if bytes.Equal(m.Payload, expected[ix]) == false {
+			t.Fatalf("Unexpected decrypted payload\n")
+		}
+		if m.isEncrypted() == true {
+			t.Fatalf("Expected the encryption attributes to be removed\n")
+		}
+	}
+	verifyMessages(t, received)
+}
+
+func TestEncryptedCompressedMessage(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	inMemoryBackend(awssqs).config.KeyProvider = makeKeyProvider(t, "key-1")
+	inMemoryBackend(awssqs).config.Compression = CompressionZstd
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	payload := bytes.Repeat([]byte("<record><id>u123</id></record>"), 20000)
+	_, err := awssqs.BatchMessagePut(queueHandle, []Message{{Payload: payload}})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(received) != 1 || received[0].IsOversize() == true || bytes.Equal(received[0].Payload, payload) == false {
+		t.Fatalf("Expected the compressed and encrypted message to be sent inline\n")
+	}
+	if len(received[0].Attribs) != 0 {
+		t.Fatalf("Unexpected attributes %v\n", received[0].Attribs)
+	}
+}
+
+func TestEncryptedCompressedMessageTooLarge(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	inMemoryBackend(awssqs).config.KeyProvider = makeKeyProvider(t, "key-1")
+	inMemoryBackend(awssqs).config.Compression = CompressionZstd
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// the compressed payload fits but not once it is encrypted
+	payload := bytes.Repeat([]byte("<record><id>u123</id></record>"), 20000)
+	probe := Message{Payload: payload}
+	_, _ = probe.compress(CompressionZstd, MAX_SQS_MESSAGE_SIZE)
+	inMemoryBackend(awssqs).config.OversizeThreshold = probe.Size() + 8
+
+	messages := []Message{{Payload: payload}}
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if messages[0].IsOversize() == false || messages[0].isCompressed() == true {
+		t.Fatalf("Expected the encrypted payload to be stored uncompressed\n")
+	}
+
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(received) != 1 || bytes.Equal(received[0].Payload, payload) == false {
+		t.Fatalf("Unexpected decrypted payload\n")
+	}
+}
+
+func TestEncryptedMessageKeys(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	backend := inMemoryBackend(awssqs)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	dir := t.TempDir()
+
+	// the keys are rotated after the first message is sent
+	oldKey, newKey := makeKeyfileLine("key-1"), makeKeyfileLine("key-2")
+	backend.config.KeyProvider = makeKeyProviderFrom(t, dir, oldKey)
+	_, err := awssqs.BatchMessagePut(queueHandle, makeStandardMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	backend.config.KeyProvider = makeKeyProviderFrom(t, dir, newKey+"\n"+oldKey)
+	_, err = awssqs.BatchMessagePut(queueHandle, makeStandardMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	keyIds := make(map[string]bool)
+	for _, m := range inMemoryQueueFor(awssqs, queueHandle).messages {
+		keyIds[*m.attributes[encryptionKeyIdAttributeName].StringValue] = true
+	}
+	if keyIds["key-1"] == false || keyIds["key-2"] == false {
+		t.Fatalf("Expected a message encrypted by each key, got %v\n", keyIds)
+	}
+
+	// a receiver without the old key cannot decrypt the first message
+	backend.config.KeyProvider = makeKeyProviderFrom(t, dir, newKey)
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != ErrUnknownKey || len(received) != 2 || received[0].Incomplete == false || received[1].Incomplete == true {
+		t.Fatalf("Expected the first message to be incomplete (%t)\n", err)
+	}
+
+	// and a receiver without a key provider cannot decrypt either
+	backend.config.KeyProvider = nil
+	_, err = awssqs.BatchMessagePut(queueHandle, makeStandardMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	backend.config.KeyProvider = makeKeyProviderFrom(t, dir, newKey)
+	_, err = awssqs.BatchMessagePut(queueHandle, makeStandardMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	backend.config.KeyProvider = nil
+	received, err = awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != ErrNoKeyProvider || len(received) != 2 || received[0].Incomplete == true || received[1].Incomplete == false {
+		t.Fatalf("Expected the encrypted message to be incomplete (%t)\n", err)
+	}
+}
+
+func TestEncryptedOversizeRedrive(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName, deadLetterQueueName)
+	inMemoryBackend(awssqs).config.KeyProvider = makeKeyProvider(t, "key-1")
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	deadLetterHandle, _ := awssqs.QueueHandle(deadLetterQueueName)
+	ctx := context.Background()
+
+	_, err := awssqs.BatchMessagePut(deadLetterHandle, makeLargeMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	deadLetters, _ := awssqs.BatchMessageGet(deadLetterHandle, MAX_SQS_BLOCK_COUNT, 0)
+	_, err = awssqs.BatchMessageRedriveWithContext(ctx, deadLetterHandle, queueHandle, deadLetters)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// the redriven message refers to the same encrypted payload
+	messages, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil || len(messages) != 1 || messages[0].IsOversize() == false {
+		t.Fatalf("Expected the redriven oversize message (%t)\n", err)
+	}
+	if bytes.Equal(messages[0].Payload, deadLetters[0].Payload) == false {
+		t.Fatalf("Unexpected redriven payload\n")
+	}
+	verifyMessages(t, messages)
+}
+
+func TestEncryptedMakeMessage(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	inMemoryBackend(awssqs).config.KeyProvider = makeKeyProvider(t, "key-1")
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeLargeMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	received, err := inMemoryBackend(awssqs).svc.ReceiveMessageWithContext(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(string(queueHandle)),
+		MessageAttributeNames: []*string{aws.String(sqs.QueueAttributeNameAll)},
+	})
+	if err != nil || len(received.Messages) != 1 {
+		t.Fatalf("Expected 1 message (%t)\n", err)
+	}
+
+	// without a key provider the message is incomplete and the reason is reported
+	message, err := makeMessage(context.Background(), *received.Messages[0], inMemoryBackend(awssqs).store, nil, defaultLogger)
+	if err != ErrNoKeyProvider || message.Incomplete == false {
+		t.Fatalf("Expected the message to be incomplete (%t)\n", err)
+	}
+
+	// made as the SQS implementation makes it
+	message, err = MakeMessageFrom(awssqs, *received.Messages[0])
+	if err != nil || message.Incomplete == true {
+		t.Fatalf("%t\n", err)
+	}
+	verifyMessages(t, []Message{*message})
+}
+
+func TestLocalKeyProviderBadKeyfile(t *testing.T) {
+
+	dir := t.TempDir()
+	for _, content := range []string{"", "# no keys", "key-1", "key-1 notbase64!", "key-1 " + base64.StdEncoding.EncodeToString([]byte("short")),
+		makeKeyfileLine("key-1") + "\n" + makeKeyfileLine("key-1")} {
+		keyfile := filepath.Join(dir, "keys")
+		os.WriteFile(keyfile, []byte(content), 0600)
+		provider, err := NewLocalKeyProvider(keyfile)
+		if err != ErrBadKeyfile || provider != nil {
+			t.Fatalf("Expected a bad keyfile for [%s], got %v\n", content, err)
+		}
+	}
+}
+
+//
+// helper methods
+//
+
+// a line of a keyfile with a new random key
+func makeKeyfileLine(keyId string) string {
+	key := make([]byte, dataKeySize)
+	rand.Read(key)
+	return keyId + " " + base64.StdEncoding.EncodeToString(key)
+}
+
+// a key provider with a single new key
+func makeKeyProvider(t *testing.T, keyId string) KeyProvider {
+	return makeKeyProviderFrom(t, t.TempDir(), "# test keys\n"+makeKeyfileLine(keyId))
+}
+
+// a key provider with the supplied keyfile content
+func makeKeyProviderFrom(t *testing.T, dir string, content string) KeyProvider {
+	keyfile := filepath.Join(dir, "keys")
+	err := os.WriteFile(keyfile, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	provider, err := NewLocalKeyProvider(keyfile)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	return provider
+}
+
+//
+// end of file
+//
@@ -0,0 +1,45 @@
+package awssqs
+
+import (
+	"sync"
+)
+
+// a cache of queue handles keyed by queue name, safe for concurrent use
+type queueHandleCache struct {
+	mu      sync.Mutex
+	handles map[string]QueueHandle
+}
+
+func newQueueHandleCache() *queueHandleCache {
+	return &queueHandleCache{handles: make(map[string]QueueHandle)}
+}
+
+// get the cached handle for the named queue
+func (c *queueHandleCache) get(queueName string) (QueueHandle, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	handle, found := c.handles[queueName]
+	return handle, found
+}
+
+// cache the handle for the named queue
+func (c *queueHandleCache) put(queueName string, handle QueueHandle) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handles[queueName] = handle
+}
+
+// forget the supplied handle, it is no longer valid
+func (c *queueHandleCache) invalidate(handle QueueHandle) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, h := range c.handles {
+		if h == handle {
+			delete(c.handles, name)
+		}
+	}
+}
+
+//
+// end of file
+//
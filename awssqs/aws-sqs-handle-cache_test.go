@@ -0,0 +1,91 @@
+package awssqs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+//
+// queue handle cache and GetQueueStats behavior tests
+//
+
+func TestQueueHandleCache(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	backend := inMemoryBackend(awssqs)
+	svc := &countingSqsService{SQSAPI: backend.svc}
+	backend.svc = svc
+
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	again, _ := awssqs.QueueHandle(inMemoryQueueName)
+	_, err := awssqs.GetMessagesAvailable(inMemoryQueueName)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if again != queueHandle || svc.urlRequests != 1 {
+		t.Fatalf("Expected the queue handle to be cached (%d requests)\n", svc.urlRequests)
+	}
+
+	// delete the queue behind our back, the cached handle is invalidated once it is seen to be bad
+	_, _ = svc.DeleteQueueWithContext(context.Background(), &sqs.DeleteQueueInput{QueueUrl: aws.String(string(queueHandle))})
+	_, err = awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, zeroWaitTime)
+	if err != ErrBadQueueHandle {
+		t.Fatalf("%t\n", err)
+	}
+	_, err = awssqs.QueueHandle(inMemoryQueueName)
+	if err != ErrBadQueueName || svc.urlRequests != 2 {
+		t.Fatalf("Expected the queue handle to be resolved again (%d requests, %t)\n", svc.urlRequests, err)
+	}
+}
+
+func TestGetQueueStats(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeStandardMessages(3))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	_, _ = awssqs.BatchMessageGet(queueHandle, 1, zeroWaitTime)
+
+	stats, err := awssqs.GetQueueStatsWithContext(context.Background(), queueHandle)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if stats.Visible != 2 || stats.InFlight != 1 || stats.Delayed != 0 {
+		t.Fatalf("Unexpected queue stats %+v\n", stats)
+	}
+	if stats.Attributes[sqs.QueueAttributeNameQueueArn] != inMemoryQueueArnPrefix+inMemoryQueueName {
+		t.Fatalf("Expected the queue attributes in the stats\n")
+	}
+
+	_, err = awssqs.GetQueueStatsWithContext(context.Background(), badQueueHandle)
+	if err != ErrBadQueueHandle {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+//
+// helper methods
+//
+
+// an SQS service that counts the queue URL requests made of it
+type countingSqsService struct {
+	sqsiface.SQSAPI
+	urlRequests int
+}
+
+func (c *countingSqsService) GetQueueUrlWithContext(ctx aws.Context, input *sqs.GetQueueUrlInput, opts ...request.Option) (*sqs.GetQueueUrlOutput, error) {
+	c.urlRequests++
+	return c.SQSAPI.GetQueueUrlWithContext(ctx, input, opts...)
+}
+
+//
+// end of file
+//
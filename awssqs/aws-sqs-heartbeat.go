@@ -0,0 +1,93 @@
+package awssqs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// the visibility timeout is extended each time this fraction of it has elapsed
+var heartbeatIntervalDivisor = time.Duration(2)
+
+type VisibilityHeartbeat interface {
+
+	// Stop stop extending the visibility timeout of the messages. The visibility timeout set by the most
+	// recent extension is not changed so delete the messages or change their visibility afterwards.
+	Stop()
+}
+
+// NewVisibilityHeartbeat factory for a heartbeat that keeps extending the visibility timeout of the supplied
+// in-flight messages (including oversize messages) until it is stopped. Each extension makes the messages
+// invisible for the timeout period, the first extension is made before this returns (the timeout may be longer
+// than the visibility timeout of the queue) and then every half timeout period.
+func NewVisibilityHeartbeat(sqs AWS_SQS, queue QueueHandle, messages []Message, timeout time.Duration) (VisibilityHeartbeat, error) {
+
+	// validate the inbound parameters
+	if sqs == nil || len(queue) == 0 {
+		return nil, ErrMissingConfiguration
+	}
+	csqs, ok := sqs.(AWS_SQS_Context)
+	if ok == false {
+		return nil, ErrContextNotSupported
+	}
+	if uint(len(messages)) > MAX_SQS_BLOCK_COUNT {
+		return nil, ErrBlockCountTooLarge
+	}
+	if timeout.Seconds() > float64(MAX_SQS_VISIBILITY_TIMEOUT) {
+		return nil, ErrVisibilityTooLarge
+	}
+	if timeout < time.Second {
+		return nil, ErrVisibilityTooSmall
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hb := &heartbeatImpl{cancel: cancel, log: loggerFor(sqs)}
+	hb.extend(ctx, csqs, queue, messages, timeout)
+	hb.wg.Add(1)
+	go hb.run(ctx, csqs, queue, messages, timeout)
+	return hb, nil
+}
+
+// this is our heartbeat implementation, extending the visibility on its own goroutine until cancelled
+type heartbeatImpl struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	log    Logger
+}
+
+// Stop stop extending the visibility timeout and wait for any extension in progress
+func (hb *heartbeatImpl) Stop() {
+	hb.cancel()
+	hb.wg.Wait()
+}
+
+// extend the visibility every interval until cancelled
+func (hb *heartbeatImpl) run(ctx context.Context, sqs AWS_SQS_Context, queue QueueHandle, messages []Message, timeout time.Duration) {
+
+	defer hb.wg.Done()
+
+	ticker := time.NewTicker(timeout / heartbeatIntervalDivisor)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hb.extend(ctx, sqs, queue, messages, timeout)
+		}
+	}
+}
+
+// extend the visibility once
+func (hb *heartbeatImpl) extend(ctx context.Context, sqs AWS_SQS_Context, queue QueueHandle, messages []Message, timeout time.Duration) {
+
+	_, err := sqs.BatchMessageVisibilityChangeWithContext(ctx, queue, messages, timeout)
+	if err != nil && ctx.Err() == nil {
+		hb.log.Warn("visibility heartbeat not successful", "queue", queue, "error", err)
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,190 @@
+package awssqs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+var extendedVisibility = 2 * inMemoryDefaultVisibilityTimeout
+
+//
+// BatchMessageVisibilityChange method invariant tests
+//
+
+func TestBatchMessageVisibilityChangeHappyDay(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeStandardMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	messages, _ := awssqs.BatchMessageGet(queueHandle, 1, zeroWaitTime)
+
+	ops, err := awssqs.BatchMessageVisibilityChange(queueHandle, messages, extendedVisibility)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if allOperationsOK(ops) == false {
+		t.Fatalf("One or more visibility change operations reported failed unexpectedly\n")
+	}
+
+	verifyVisibility(t, awssqs, queueHandle, clock, extendedVisibility)
+}
+
+func TestBatchMessageVisibilityChangeOversize(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeLargeMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	messages, _ := awssqs.BatchMessageGet(queueHandle, 1, zeroWaitTime)
+	if messages[0].IsOversize() == false {
+		t.Fatalf("Expected an oversize message\n")
+	}
+
+	ops, err := awssqs.BatchMessageVisibilityChange(queueHandle, messages, extendedVisibility)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if allOperationsOK(ops) == false {
+		t.Fatalf("One or more visibility change operations reported failed unexpectedly\n")
+	}
+
+	verifyVisibility(t, awssqs, queueHandle, clock, extendedVisibility)
+}
+
+func TestBatchMessageVisibilityChangeBadReceiptHandle(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	messages := makeStandardMessages(1)
+	messages[0].ReceiptHandle = badReceiptHandle
+
+	ops, err := awssqs.BatchMessageVisibilityChange(queueHandle, messages, extendedVisibility)
+	if err != ErrOneOrMoreOperationsUnsuccessful {
+		t.Fatalf("%t\n", err)
+	}
+	if ops[0] == true {
+		t.Fatalf("Visibility change operation reported success incorrectly\n")
+	}
+}
+
+func TestBatchMessageVisibilityChangeBadTimeout(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	tooLong := time.Duration(MAX_SQS_VISIBILITY_TIMEOUT+1) * time.Second
+	_, err := awssqs.BatchMessageVisibilityChange(queueHandle, makeStandardMessages(1), tooLong)
+	if err != ErrVisibilityTooLarge {
+		t.Fatalf("%t\n", err)
+	}
+	_, err = awssqs.BatchMessageVisibilityChange(queueHandle, makeStandardMessages(1), -time.Second)
+	if err != ErrVisibilityNegative {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+//
+// VisibilityHeartbeat behavior tests
+//
+
+func TestVisibilityHeartbeatHappyDay(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeLargeMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	messages, _ := awssqs.BatchMessageGet(queueHandle, 1, zeroWaitTime)
+
+	// the test clock is not moving so once the heartbeat has extended the visibility, the message
+	// becomes visible one timeout period from now
+	timeout := 1 * time.Second
+	hb, err := NewVisibilityHeartbeat(awssqs, queueHandle, messages, timeout)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	time.Sleep(timeout)
+	hb.Stop()
+
+	verifyVisibility(t, awssqs, queueHandle, clock, timeout)
+}
+
+func TestVisibilityHeartbeatShortQueueVisibility(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	admin, _ := NewAwsSqsAdminFor(awssqs)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+
+	err := admin.SetQueueAttributes(context.Background(), queueHandle, map[string]string{sqs.QueueAttributeNameVisibilityTimeout: "1"})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	_, err = awssqs.BatchMessagePut(queueHandle, makeStandardMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	messages, _ := awssqs.BatchMessageGet(queueHandle, 1, zeroWaitTime)
+
+	// the heartbeat timeout is much longer than the queue visibility timeout so the visibility must be
+	// extended before the first interval elapses
+	timeout := 10 * time.Second
+	hb, err := NewVisibilityHeartbeat(awssqs, queueHandle, messages, timeout)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	hb.Stop()
+
+	verifyVisibility(t, awssqs, queueHandle, clock, timeout)
+}
+
+func TestVisibilityHeartbeatBadTimeout(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := NewVisibilityHeartbeat(awssqs, queueHandle, makeStandardMessages(1), 0)
+	if err != ErrVisibilityTooSmall {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+//
+// helper methods
+//
+
+// verify a single message becomes visible at the expected time from now and not before
+func verifyVisibility(t *testing.T, awssqs AWS_SQS, queue QueueHandle, clock *testClock, visibility time.Duration) {
+
+	clock.advance(visibility - time.Second)
+	messages, _ := awssqs.BatchMessageGet(queue, 1, zeroWaitTime)
+	if len(messages) != 0 {
+		t.Fatalf("Received a message before its visibility timeout expired\n")
+	}
+
+	clock.advance(time.Second)
+	messages, _ = awssqs.BatchMessageGet(queue, 1, zeroWaitTime)
+	if len(messages) != 1 {
+		t.Fatalf("Expected the message to be visible once its visibility timeout expired\n")
+	}
+}
+
+//
+// end of file
+//
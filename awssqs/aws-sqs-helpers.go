@@ -1,18 +1,24 @@
 package awssqs
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
-	"log"
+	"github.com/google/uuid"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// log a warning if any SQS request takes longer than this
-var warnIfRequestTakesLonger = int64(250)
+// FIFO queue names (and so queue handles) always have this suffix
+var fifoQueueSuffix = ".fifo"
 
 // construct an AWS send structure when provided a message
-// the index value is used to differentiate requests when they are made in blocks
-func constructSend(message Message, index int, mGroup string) *sqs.SendMessageBatchRequestEntry {
+// the index value is used to differentiate requests when they are made in blocks, any delay is relative to now
+func constructSend(message Message, index int, fifo bool, now time.Time) *sqs.SendMessageBatchRequestEntry {
 
 	// standard message
 	e := sqs.SendMessageBatchRequestEntry{
@@ -25,14 +31,70 @@ func constructSend(message Message, index int, mGroup string) *sqs.SendMessageBa
 		e.MessageAttributes = awsAttribsFromMessageAttribs(message.Attribs)
 	}
 
-	// if we need to add a message group
-	if len(mGroup) != 0 {
+	// delays are in whole seconds, round up so messages are never early
+	delay := message.sendDelay(now)
+	if delay != 0 {
+		e.DelaySeconds = aws.Int64(int64(math.Ceil(delay.Seconds())))
+	}
+
+	// FIFO messages need a message group and may have a deduplication id
+	if fifo == true {
+		mGroup := message.MessageGroupId
+		if len(mGroup) == 0 {
+			mGroup = DefaultMessageGroupId
+		}
 		e.MessageGroupId = aws.String(mGroup)
+		if len(message.MessageDeduplicationId) != 0 {
+			e.MessageDeduplicationId = aws.String(message.MessageDeduplicationId)
+		}
 	}
 
 	return &e
 }
 
+// is the queue a FIFO queue
+func isFifoQueue(queue QueueHandle) bool {
+	return strings.HasSuffix(string(queue), fifoQueueSuffix)
+}
+
+// make a deduplication id from the message payload, the same way SQS does for content based deduplication
+func contentDeduplicationId(message Message) string {
+	return fmt.Sprintf("%x", sha256.Sum256(message.Payload))
+}
+
+// the queue name is the last element of the queue ARN
+func queueNameFromArn(arn string) string {
+	return arn[strings.LastIndex(arn, ":")+1:]
+}
+
+// the redrive policy queue attribute, SQS reports the receive count as a number but accepts a string
+type redrivePolicyAttribute struct {
+	DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+	MaxReceiveCount     interface{} `json:"maxReceiveCount"`
+}
+
+// make the redrive policy queue attribute value
+func makeRedrivePolicy(deadLetterArn string, maxReceiveCount uint) string {
+	buf, _ := json.Marshal(redrivePolicyAttribute{deadLetterArn, strconv.Itoa(int(maxReceiveCount))})
+	return string(buf)
+}
+
+// parse the redrive policy queue attribute value returning the dead letter queue ARN and maximum receive count
+func parseRedrivePolicy(policy string) (string, uint, error) {
+
+	var attribute redrivePolicyAttribute
+	err := json.Unmarshal([]byte(policy), &attribute)
+	if err != nil || len(attribute.DeadLetterTargetArn) == 0 {
+		return "", 0, ErrBadRedrivePolicy
+	}
+
+	count, err := strconv.Atoi(fmt.Sprint(attribute.MaxReceiveCount))
+	if err != nil || count < 1 {
+		return "", 0, ErrBadRedrivePolicy
+	}
+	return attribute.DeadLetterTargetArn, uint(count), nil
+}
+
 // construct an AWS delete object when provided a receipt handle
 // the index value is used to differentiate requests when they are made in blocks
 func constructDelete(deleteHandle ReceiptHandle, index int) *sqs.DeleteMessageBatchRequestEntry {
@@ -43,23 +105,98 @@ func constructDelete(deleteHandle ReceiptHandle, index int) *sqs.DeleteMessageBa
 	}
 }
 
+// construct an AWS change visibility object when provided a receipt handle and the new timeout
+// the index value is used to differentiate requests when they are made in blocks
+func constructChangeVisibility(receiptHandle ReceiptHandle, index int, timeout time.Duration) *sqs.ChangeMessageVisibilityBatchRequestEntry {
+
+	return &sqs.ChangeMessageVisibilityBatchRequestEntry{
+		ReceiptHandle:     aws.String(string(receiptHandle)),
+		Id:                aws.String(strconv.Itoa(index)),
+		VisibilityTimeout: aws.Int64(int64(timeout.Seconds())),
+	}
+}
+
 func awsAttribsFromMessageAttribs(attribs Attributes) map[string]*sqs.MessageAttributeValue {
 	attributes := make(map[string]*sqs.MessageAttributeValue)
 	for _, a := range attribs {
-		attributes[a.Name] = &sqs.MessageAttributeValue{
-			DataType:    aws.String("String"),
-			StringValue: aws.String(a.Value),
+		v := &sqs.MessageAttributeValue{DataType: aws.String(a.dataType())}
+		if a.isBinary() == true {
+			v.BinaryValue = a.BinaryValue
+		} else {
+			v.StringValue = aws.String(a.Value)
 		}
+		attributes[a.Name] = v
 	}
 	return attributes
 }
 
-// sometimes it is interesting to know if our SQS queries are slow
-func warnIfSlow(elapsed int64, prefix string) {
+// OpStatus the simple status of each entry in the batch result
+func (br BatchResult) OpStatus() []OpStatus {
+
+	ops := make([]OpStatus, len(br))
+	for ix, r := range br {
+		ops[ix] = OpStatus(r.Success)
+	}
+	return ops
+}
+
+// AllSuccessful were all the entries in the batch result successful
+func (br BatchResult) AllSuccessful() bool {
+
+	for _, r := range br {
+		if r.Success == false {
+			return false
+		}
+	}
+	return true
+}
+
+// the result of a successful batch entry
+var successfulEntry = BatchEntryResult{Success: true}
+
+// make the result of a failed batch entry
+func failedEntry(code string, message string, retryable bool) BatchEntryResult {
+	return BatchEntryResult{Code: code, Message: message, Retryable: retryable}
+}
+
+// make the result of a failed batch entry from the AWS failure details. Failures that are not the
+// fault of the sender can be retried
+func failedEntryFromAws(f *sqs.BatchResultErrorEntry) BatchEntryResult {
+	return BatchEntryResult{
+		Code:        aws.StringValue(f.Code),
+		Message:     aws.StringValue(f.Message),
+		SenderFault: aws.BoolValue(f.SenderFault),
+		Retryable:   aws.BoolValue(f.SenderFault) == false,
+	}
+}
 
-	if elapsed >= warnIfRequestTakesLonger {
-		log.Printf("INFO: %s elapsed %d ms", prefix, elapsed)
+// the size above which messages are oversize and their payloads stored
+func (awsi *awsSqsImpl) oversizeThreshold() uint {
+	if awsi.config.OversizeThreshold == 0 {
+		return MAX_SQS_MESSAGE_SIZE
 	}
+	return awsi.config.OversizeThreshold
+}
+
+// make a unique key for a stored payload, prefixed as configured
+func (awsi *awsSqsImpl) payloadKey(queue QueueHandle, now time.Time) string {
+	if len(awsi.config.PayloadKeyPrefix) == 0 {
+		return uuid.New().String()
+	}
+	prefix := strings.NewReplacer(
+		"{queue}", queueNameFromHandle(queue),
+		"{date}", now.UTC().Format("2006-01-02"),
+		"{year}", now.UTC().Format("2006"),
+		"{month}", now.UTC().Format("01"),
+		"{day}", now.UTC().Format("02"),
+	).Replace(awsi.config.PayloadKeyPrefix)
+	return prefix + uuid.New().String()
+}
+
+// the queue name is the last element of the queue handle (URL)
+func queueNameFromHandle(queue QueueHandle) string {
+	q := string(queue)
+	return q[strings.LastIndex(q, "/")+1:]
 }
 
 //
@@ -1,23 +1,38 @@
 package awssqs
 
 import (
-	"log"
+	"context"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
 var emptyOpList = make([]OpStatus, 0)
+var emptyBatchResult = make(BatchResult, 0)
 var emptyMessageList = make([]Message, 0)
 
+// the maximum number of concurrent receives made by a parallel receive
+var parallelReceiveConcurrency = uint(10)
+
+// the maximum number of oversize message payloads read concurrently
+var payloadReadConcurrency = 10
+
 // this is our interface implementation
 type awsSqsImpl struct {
-	config AwsSqsConfig
-	svc    *sqs.SQS
+	config   AwsSqsConfig
+	svc      sqsiface.SQSAPI
+	store    PayloadStore // used for oversize messages
+	log      Logger
+	observer Observer
+	handles  *queueHandleCache // queue handles by queue name
+	retry    RetryPolicy
+	now      func() time.Time // the current time, used for scheduled messages
 }
 
 // factory for our SQS interface
@@ -35,32 +50,82 @@ func newAwsSqs(config AwsSqsConfig) (AWS_SQS, error) {
 
 	svc := sqs.New(sess)
 
-	return &awsSqsImpl{config, svc}, nil
+	if validCompression(config.Compression) == false {
+		return nil, ErrBadCompression
+	}
+	if validPointerFormat(config.PointerFormat) == false {
+		return nil, ErrBadPointerFormat
+	}
+	if config.OversizeThreshold > MAX_SQS_MESSAGE_SIZE {
+		return nil, ErrBadOversizeThreshold
+	}
+
+	// use the default payload store if none is configured
+	store := config.PayloadStore
+	if store == nil {
+		store = defaultPayloadStore
+	}
+
+	// use the default logger if none is configured
+	logger := config.Logger
+	if logger == nil {
+		logger = defaultLogger
+	}
+
+	// log slow requests if no observer is configured
+	observer := config.Observer
+	if observer == nil {
+		observer = newSlowRequestObserver(logger)
+	}
+
+	return &awsSqsImpl{config, svc, store, logger, observer, newQueueHandleCache(), newRetryPolicy(config.RetryPolicy), time.Now}, nil
 }
 
 // QueueHandle get a queue handle (URL) when provided a queue name
 func (awsi *awsSqsImpl) QueueHandle(queueName string) (QueueHandle, error) {
+	return awsi.QueueHandleWithContext(context.Background(), queueName)
+}
+
+// QueueHandleWithContext get a queue handle (URL) when provided a queue name. Handles are cached until
+// an operation using one reports that it is bad
+func (awsi *awsSqsImpl) QueueHandleWithContext(ctx context.Context, queueName string) (QueueHandle, error) {
+
+	// use the cached handle if we have one
+	handle, found := awsi.handles.get(queueName)
+	if found == true {
+		return handle, nil
+	}
 
 	// get the queue URL from the name
-	result, err := awsi.svc.GetQueueUrl(&sqs.GetQueueUrlInput{
+	result, err := awsi.svc.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{
 		QueueName: aws.String(queueName),
 	})
 
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 		if strings.HasPrefix(err.Error(), sqs.ErrCodeQueueDoesNotExist) {
 			return "", ErrBadQueueName
 		}
 		return "", err
 	}
 
-	return QueueHandle(*result.QueueUrl), nil
+	handle = QueueHandle(*result.QueueUrl)
+	awsi.handles.put(queueName, handle)
+	return handle, nil
 }
 
 // GetMessagesAvailable get the number of messages available in the specified queue
 func (awsi *awsSqsImpl) GetMessagesAvailable(queueName string) (uint, error) {
+	return awsi.GetMessagesAvailableWithContext(context.Background(), queueName)
+}
+
+// GetMessagesAvailableWithContext get the number of messages available in the specified queue
+func (awsi *awsSqsImpl) GetMessagesAvailableWithContext(ctx context.Context, queueName string) (uint, error) {
 
 	// get the queue handle
-	queue, err := awsi.QueueHandle(queueName)
+	queue, err := awsi.QueueHandleWithContext(ctx, queueName)
 	if err != nil {
 		return 0, err
 	}
@@ -68,13 +133,21 @@ func (awsi *awsSqsImpl) GetMessagesAvailable(queueName string) (uint, error) {
 	// and get the necessary attribute
 	q := string(queue)
 	attr := "ApproximateNumberOfMessages"
-	res, err := awsi.svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+	res, err := awsi.svc.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
 		QueueUrl: &q,
 		AttributeNames: []*string{
 			&attr,
 		},
 	})
 	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		// the cached handle may refer to a queue that has since been deleted
+		if strings.HasPrefix(err.Error(), sqs.ErrCodeQueueDoesNotExist) {
+			awsi.handles.invalidate(queue)
+			return 0, ErrBadQueueName
+		}
 		return 0, err
 	}
 
@@ -82,9 +155,53 @@ func (awsi *awsSqsImpl) GetMessagesAvailable(queueName string) (uint, error) {
 	return uint(count), nil
 }
 
+// GetQueueStats get the message counts and all the attributes of the specified queue
+func (awsi *awsSqsImpl) GetQueueStats(queue QueueHandle) (QueueStats, error) {
+	return awsi.GetQueueStatsWithContext(context.Background(), queue)
+}
+
+// GetQueueStatsWithContext get the message counts and all the attributes of the specified queue
+func (awsi *awsSqsImpl) GetQueueStatsWithContext(ctx context.Context, queue QueueHandle) (QueueStats, error) {
+
+	q := string(queue)
+	res, err := awsi.svc.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       &q,
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameAll)},
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return QueueStats{}, ctx.Err()
+		}
+		if strings.HasPrefix(err.Error(), sqs.ErrCodeQueueDoesNotExist) {
+			awsi.handles.invalidate(queue)
+			return QueueStats{}, ErrBadQueueHandle
+		}
+		return QueueStats{}, err
+	}
+
+	attributes := aws.StringValueMap(res.Attributes)
+	count := func(name string) uint {
+		n, _ := strconv.Atoi(attributes[name])
+		return uint(n)
+	}
+
+	return QueueStats{
+		Visible:    count(sqs.QueueAttributeNameApproximateNumberOfMessages),
+		InFlight:   count(sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible),
+		Delayed:    count(sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed),
+		Attributes: attributes,
+	}, nil
+}
+
 // BatchMessageGet get a batch of messages from the specified queue. Will return on receipt of any messages
 // without waiting and will wait no longer than the wait time if no messages are received.
 func (awsi *awsSqsImpl) BatchMessageGet(queue QueueHandle, maxMessages uint, waitTime time.Duration) ([]Message, error) {
+	return awsi.BatchMessageGetWithContext(context.Background(), queue, maxMessages, waitTime)
+}
+
+// BatchMessageGetWithContext get a batch of messages from the specified queue. Cancelling the context
+// abandons any wait for messages and any read of oversize message payloads.
+func (awsi *awsSqsImpl) BatchMessageGetWithContext(ctx context.Context, queue QueueHandle, maxMessages uint, waitTime time.Duration) ([]Message, error) {
 
 	// ensure the block size is not too large
 	if maxMessages > MAX_SQS_BLOCK_COUNT {
@@ -96,54 +213,198 @@ func (awsi *awsSqsImpl) BatchMessageGet(queue QueueHandle, maxMessages uint, wai
 		return emptyMessageList, ErrWaitTooLarge
 	}
 
-	q := string(queue)
+	start := time.Now()
+	received, err := awsi.receive(ctx, queue, maxMessages, waitTime)
+	if err != nil {
+		return emptyMessageList, err
+	}
+	return awsi.makeMessages(ctx, queue, received, time.Since(start))
+}
+
+// BatchMessageGetParallel get up to maxMessages messages from the specified queue using several concurrent
+// receives. Returns once it has the requested number of messages or once the wait time has elapsed
+func (awsi *awsSqsImpl) BatchMessageGetParallel(queue QueueHandle, maxMessages uint, waitTime time.Duration) ([]Message, error) {
+	return awsi.BatchMessageGetParallelWithContext(context.Background(), queue, maxMessages, waitTime)
+}
+
+// BatchMessageGetParallelWithContext get up to maxMessages messages from the specified queue using several
+// concurrent receives. Cancelling the context abandons the receives still waiting
+func (awsi *awsSqsImpl) BatchMessageGetParallelWithContext(ctx context.Context, queue QueueHandle, maxMessages uint, waitTime time.Duration) ([]Message, error) {
+
+	// ensure the block size is not too large
+	if maxMessages > MAX_SQS_PARALLEL_BLOCK_COUNT {
+		return emptyMessageList, ErrParallelBlockCountTooLarge
+	}
+
+	// ensure the wait time is not too large
+	if waitTime.Seconds() > float64(MAX_SQS_WAIT_TIME) {
+		return emptyMessageList, ErrWaitTooLarge
+	}
 
 	start := time.Now()
-	result, err := awsi.svc.ReceiveMessage(&sqs.ReceiveMessageInput{
-		AttributeNames: []*string{
-			aws.String(sqs.QueueAttributeNameAll),
-		},
-		MessageAttributeNames: []*string{
-			aws.String(sqs.QueueAttributeNameAll),
-		},
-		QueueUrl:            &q,
-		MaxNumberOfMessages: aws.Int64(int64(maxMessages)),
-		WaitTimeSeconds:     aws.Int64(int64(waitTime.Seconds())),
+	deadline := start.Add(waitTime)
+
+	// each receiver claims the number of messages it receives next from those outstanding and gives
+	// back the ones it did not get, receivers finish their current receive after any of them fails
+	var mu sync.Mutex
+	outstanding := maxMessages
+	received := make([]*sqs.Message, 0, maxMessages)
+	var receiveErr error
+
+	claim := func() uint {
+		mu.Lock()
+		defer mu.Unlock()
+		if receiveErr != nil {
+			return 0
+		}
+		count := outstanding
+		if count > MAX_SQS_BLOCK_COUNT {
+			count = MAX_SQS_BLOCK_COUNT
+		}
+		outstanding -= count
+		return count
+	}
+
+	var wg sync.WaitGroup
+	receivers := (maxMessages + MAX_SQS_BLOCK_COUNT - 1) / MAX_SQS_BLOCK_COUNT
+	if receivers > parallelReceiveConcurrency {
+		receivers = parallelReceiveConcurrency
+	}
+	for r := uint(0); r < receivers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for first := true; first == true || time.Until(deadline) >= time.Second; first = false {
+				count := claim()
+				if count == 0 {
+					return
+				}
+				wait := time.Until(deadline).Truncate(time.Second)
+				if wait < 0 {
+					wait = 0
+				}
+				messages, err := awsi.receive(ctx, queue, count, wait)
+
+				mu.Lock()
+				received = append(received, messages...)
+				outstanding += count - uint(len(messages))
+				if err != nil && receiveErr == nil {
+					receiveErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// messages received before an error are returned along with it
+	messages, err := awsi.makeMessages(ctx, queue, received, time.Since(start))
+	if receiveErr != nil {
+		return messages, receiveErr
+	}
+	return messages, err
+}
+
+// receive up to maxMessages messages, re-sending any scheduled messages that are not yet due
+func (awsi *awsSqsImpl) receive(ctx context.Context, queue QueueHandle, maxMessages uint, waitTime time.Duration) ([]*sqs.Message, error) {
+
+	q := string(queue)
+
+	var result *sqs.ReceiveMessageOutput
+	err := awsi.retry.do(ctx, func() error {
+		start := time.Now()
+		var err error
+		result, err = awsi.svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			AttributeNames: []*string{
+				aws.String(sqs.QueueAttributeNameAll),
+			},
+			MessageAttributeNames: []*string{
+				aws.String(sqs.QueueAttributeNameAll),
+			},
+			QueueUrl:            &q,
+			MaxNumberOfMessages: aws.Int64(int64(maxMessages)),
+			WaitTimeSeconds:     aws.Int64(int64(waitTime.Seconds())),
+		})
+		if err != nil {
+			awsi.observer.Observe(ObserverEvent{Operation: OperationReceive, Queue: queue, Latency: time.Since(start), Err: err})
+		}
+		return err
 	})
-	elapsed := int64(time.Since(start) / time.Millisecond)
 
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if strings.HasPrefix(err.Error(), sqs.ErrCodeQueueDoesNotExist) {
-			return emptyMessageList, ErrBadQueueHandle
+			awsi.handles.invalidate(queue)
+			return nil, ErrBadQueueHandle
 		}
-		return emptyMessageList, err
+		return nil, err
 	}
 
+	// scheduled messages that are not yet due are re-sent rather than returned
+	now := awsi.now()
+	due := make([]*sqs.Message, 0, len(result.Messages))
+	hops := make([]*sqs.Message, 0)
+	for _, m := range result.Messages {
+		if isScheduledHop(m, now) == true {
+			hops = append(hops, m)
+		} else {
+			due = append(due, m)
+		}
+	}
+	if len(hops) != 0 {
+		awsi.resendScheduledHops(ctx, queue, hops)
+	}
+	return due, nil
+}
+
+// make our messages from the received AWS structures, reading oversize message payloads concurrently
+func (awsi *awsSqsImpl) makeMessages(ctx context.Context, queue QueueHandle, received []*sqs.Message, elapsed time.Duration) ([]Message, error) {
+
 	// if we did not get any messages
-	sz := len(result.Messages)
+	sz := len(received)
 	if sz == 0 {
+		awsi.observer.Observe(ObserverEvent{Operation: OperationReceive, Queue: queue, Latency: elapsed})
 		return emptyMessageList, nil
 	}
 
-	// we want to warn if the receive took a long time (and yielded messages)
-	warnIfSlow(elapsed, "ReceiveMessage")
+	messages := make([]Message, sz)
+	errs := make([]error, sz)
+	store := awsi.storeFor(queue)
+	slots := make(chan struct{}, payloadReadConcurrency)
+	var wg sync.WaitGroup
+	for ix, m := range received {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(ix int, awsMessage *sqs.Message) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			m, err := makeMessage(ctx, *awsMessage, store, awsi.config.KeyProvider, awsi.log)
+			m.unschedule()
+			messages[ix] = *m
+			errs[ix] = err
+		}(ix, m)
+	}
+	wg.Wait()
 
-	// build the response message set from the returned AWS structures
-	messages := make([]Message, 0, sz)
 	var returnErr error
 	wasError := false
-	for _, m := range result.Messages {
-		// make a new message and append to the list
-		m, err := MakeMessage(*m)
-		messages = append(messages, *m)
-		if err != nil {
+	var incomplete, totalSize uint
+	for ix := range messages {
+		totalSize += messages[ix].Size()
+		if errs[ix] != nil {
 			// sometimes we have incomplete messages so capture that info here...
 			// incomplete messages are marked as such so can be handled elsewhere
 			wasError = true
-			returnErr = err
+			returnErr = errs[ix]
+			incomplete++
 		}
 	}
 
+	awsi.observer.Observe(ObserverEvent{Operation: OperationReceive, Queue: queue, Latency: elapsed,
+		Messages: uint(sz), Bytes: totalSize, Failures: incomplete, Err: returnErr})
+
 	// if one (or more) error occurred, return it with the list of messages
 	if wasError == true {
 		return messages, returnErr
@@ -155,160 +416,394 @@ func (awsi *awsSqsImpl) BatchMessageGet(queue QueueHandle, maxMessages uint, wai
 // in the event of one or more failure, the operation status array will indicate which
 // messages were processed successfully and which were not.
 func (awsi *awsSqsImpl) BatchMessagePut(queue QueueHandle, messages []Message) ([]OpStatus, error) {
+	return awsi.BatchMessagePutWithContext(context.Background(), queue, messages)
+}
+
+// BatchMessagePutWithContext put a batch of messages to the specified queue. Cancelling the context
+// abandons the send and any write of oversize message payloads.
+func (awsi *awsSqsImpl) BatchMessagePutWithContext(ctx context.Context, queue QueueHandle, messages []Message) ([]OpStatus, error) {
+	results, err := awsi.BatchMessagePutWithResultWithContext(ctx, queue, messages)
+	return results.OpStatus(), err
+}
+
+// BatchMessagePutWithResult put a batch of messages to the specified queue.
+// in the event of one or more failure, the batch result will indicate which messages were processed
+// successfully and the reason the others were not. Failures are retried according to the retry policy.
+// Messages that are not sent are left as they were, any payload stored for them is deleted
+func (awsi *awsSqsImpl) BatchMessagePutWithResult(queue QueueHandle, messages []Message) (BatchResult, error) {
+	return awsi.BatchMessagePutWithResultWithContext(context.Background(), queue, messages)
+}
+
+// BatchMessagePutWithResultWithContext put a batch of messages to the specified queue, reporting the outcome
+// of each. Cancelling the context abandons the send and any write of oversize message payloads.
+func (awsi *awsSqsImpl) BatchMessagePutWithResultWithContext(ctx context.Context, queue QueueHandle, messages []Message) (BatchResult, error) {
+
+	// remember the messages as they were, sending updates them
+	originals := make([]Message, len(messages))
+	for ix := range messages {
+		messages[ix].enqueued = false
+		originals[ix] = messages[ix]
+		originals[ix].Attribs = append(Attributes{}, messages[ix].Attribs...)
+	}
+
+	results, err := awsi.retryBatch(ctx, messages, func(batch []Message) (BatchResult, error) {
+		return awsi.batchMessagePut(ctx, queue, batch)
+	})
+	awsi.rollbackPut(ctx, queue, originals, messages, results)
+	return results, err
+}
+
+// restore the messages that were not sent to their original state, deleting any payloads stored for
+// them so sending them again does not store a duplicate. A message that SQS may have enqueued (the send
+// request got no response or the checksums did not match) keeps its payload and is not restored
+func (awsi *awsSqsImpl) rollbackPut(ctx context.Context, queue QueueHandle, originals []Message, messages []Message, results BatchResult) {
+
+	// delete the payloads even if the send was cancelled
+	ctx = context.WithoutCancel(ctx)
+	store := awsi.storeFor(queue)
+	for ix := range messages {
+		if len(results) == len(messages) && results[ix].Success == true {
+			continue
+		}
+		if messages[ix].enqueued == true {
+			continue
+		}
+
+		// only the payloads we stored, an oversize message may share its payload with another message
+		if originals[ix].oversize == false && messages[ix].oversize == true {
+			err := messages[ix].deleteOversizeMessage(ctx, store)
+			if err != nil {
+				bucket, key := messages[ix].getBucketAttributes(messages[ix].ReceiptHandle)
+				awsi.log.Warn("failed deleting payload of unsent message", "bucket", bucket, "key", key, "error", err)
+			}
+		}
+		consumed := originals[ix].PayloadReader != nil && messages[ix].payloadConsumed() == true
+		messages[ix] = originals[ix]
+		if consumed == true {
+			messages[ix].PayloadReader = consumedPayloadReader{}
+		}
+	}
+}
+
+// make a single attempt to put a batch of messages
+func (awsi *awsSqsImpl) batchMessagePut(ctx context.Context, queue QueueHandle, messages []Message) (BatchResult, error) {
 
 	// early exit if no messages provided
 	sz := len(messages)
 	if sz == 0 {
-		return emptyOpList, nil
+		return emptyBatchResult, nil
 	}
 
 	// ensure the block size is not too large
 	if uint(sz) > MAX_SQS_BLOCK_COUNT {
-		return emptyOpList, ErrBlockCountTooLarge
+		return emptyBatchResult, ErrBlockCountTooLarge
 	}
 
-	// our operation status array
-	ops := make([]OpStatus, sz)
+	// our batch result array
+	results := make(BatchResult, sz)
 
-	// initialize the operation status array to all successful and convert any
+	// initialize the batch result array to all successful and convert any
 	// oversize messages (use index access to the array because this updates the messages)
+	store := awsi.storeFor(queue)
+	fifo := isFifoQueue(queue)
+	now := awsi.now()
+	var envelope *envelopeKey
+	threshold := awsi.oversizeThreshold()
 	for ix := range messages {
-		ops[ix] = true
+		results[ix] = successfulEntry
+
+		// only standard queues support per-message delays, long delays are scheduled
+		delay := messages[ix].Delay
+		if delay < 0 || (fifo == true && delay != 0) {
+			results[ix] = failedEntry(BatchEntryCodeInvalidDelay, "the message delay is not valid for this queue", false)
+			continue
+		}
+		if delay > time.Duration(MAX_SQS_DELAY)*time.Second {
+			messages[ix].schedule(now)
+		}
+
+		// a message sent again as it was received has already been compressed, encrypted and stored as necessary
+		if messages[ix].resend == true {
+			continue
+		}
+
+		// streamed payloads are stored as they are read so they cannot be compressed or encrypted, the reader
+		// cannot be read again so a failure cannot be retried
+		if messages[ix].PayloadReader != nil && messages[ix].IsOversize() == false {
+			if awsi.config.KeyProvider != nil {
+				results[ix] = failedEntry(BatchEntryCodeEncryptionFailure, "streamed payloads cannot be encrypted", false)
+				continue
+			}
+			digest, err := messages[ix].convertToStreamedMessage(ctx, store, awsi.config.MessageBucketName, awsi.payloadKey(queue, now), awsi.config.PointerFormat)
+			if err != nil {
+				awsi.log.Warn("failed storing streamed payload, ignoring further processing for it", "error", err)
+				results[ix] = failedEntry(BatchEntryCodePayloadStoreFailure, err.Error(), false)
+				continue
+			}
+			if fifo == true && awsi.config.ContentBasedDeduplication == true && len(messages[ix].MessageDeduplicationId) == 0 {
+				messages[ix].MessageDeduplicationId = digest
+			}
+			continue
+		}
 
+		// derive the deduplication id before the payload of an oversize message is replaced
+		if fifo == true && awsi.config.ContentBasedDeduplication == true && len(messages[ix].MessageDeduplicationId) == 0 {
+			messages[ix].MessageDeduplicationId = contentDeduplicationId(messages[ix])
+		}
+
+		// try compressing the payload before storing it
 		sz := messages[ix].Size()
-		if sz > MAX_SQS_MESSAGE_SIZE {
-			err := messages[ix].ConvertToOversizeMessage(awsi.config.MessageBucketName)
+		uncompressed := messages[ix]
+		compressed := false
+		if sz > threshold && awsi.config.AlwaysOffload == false && awsi.config.Compression != CompressionNone && messages[ix].isCompressed() == false {
+			fits, err := messages[ix].compress(awsi.config.Compression, threshold)
 			if err != nil {
-				log.Printf("WARNING: failed converting oversize message, ignoring further processing for it")
-				ops[ix] = false
+				awsi.log.Warn("failed compressing message payload", "error", err)
+			}
+			if fits == true {
+				compressed = true
+				sz = messages[ix].Size()
+			}
+		}
+
+		// encrypt the payload before sending or storing it, the messages share a data key
+		if awsi.config.KeyProvider != nil && messages[ix].isEncrypted() == false && messages[ix].IsOversize() == false {
+			var err error
+			if envelope == nil {
+				envelope, err = newEnvelopeKey(ctx, awsi.config.KeyProvider)
+			}
+			if err == nil {
+				err = messages[ix].encrypt(envelope)
+			}
+
+			// encryption grows the payload, if the compressed payload no longer fits it is stored uncompressed
+			if err == nil && compressed == true && messages[ix].Size() > threshold {
+				messages[ix] = uncompressed
+				err = messages[ix].encrypt(envelope)
+			}
+			if err != nil {
+				awsi.log.Warn("failed encrypting message payload, ignoring further processing for it", "error", err)
+				results[ix] = failedEntry(BatchEntryCodeEncryptionFailure, err.Error(), awsi.retry.retryable(err))
+				continue
+			}
+			sz = messages[ix].Size()
+		}
+
+		if sz > threshold || awsi.config.AlwaysOffload == true {
+			err := messages[ix].convertToOversizeMessage(ctx, store, awsi.config.MessageBucketName, awsi.payloadKey(queue, now), awsi.config.PointerFormat)
+			if err != nil {
+				awsi.log.Warn("failed converting oversize message, ignoring further processing for it", "error", err)
+				results[ix] = failedEntry(BatchEntryCodePayloadStoreFailure, err.Error(), true)
 			}
 		}
 	}
 
-	// calculate the total block size and the number of messages that are larger than the maximum message size
+	// calculate the total block size of the messages we are still sending
 	var totalSize uint = 0
 	for ix := range messages {
-		totalSize += messages[ix].Size()
+		if results[ix].Success == true {
+			totalSize += messages[ix].Size()
+		}
 	}
 
 	// if the total block size is too large then we can split the block in half and handle each one individually
 	if totalSize > MAX_SQS_BLOCK_SIZE {
 		half := sz / 2
 		if half == 0 {
-			// an insane situation, bomb out
-			log.Fatalf("ERROR: cannot split block further, aborting")
+			// an insane situation, give up on this message
+			awsi.log.Error("cannot split block further", "size", totalSize)
+			results[0] = failedEntry(BatchEntryCodeBlockTooLarge, ErrBlockTooLarge.Error(), false)
+			return results, ErrBlockTooLarge
 		}
-		log.Printf("INFO: blocksize too large, splitting at %d", half)
-		op1, err1 := awsi.BatchMessagePut(queue, messages[0:half])
-		op2, err2 := awsi.BatchMessagePut(queue, messages[half:])
-		op1 = append(op1, op2...)
+		awsi.log.Info("blocksize too large, splitting", "size", totalSize, "at", half)
+		awsi.observer.Observe(ObserverEvent{Operation: OperationBlockSplit, Queue: queue, Messages: uint(sz), Bytes: totalSize})
+		res1, err1 := awsi.batchMessagePut(ctx, queue, messages[0:half])
+		res2, err2 := awsi.batchMessagePut(ctx, queue, messages[half:])
+		res1 = append(awsi.requestFailureResult(res1, half, err1), awsi.requestFailureResult(res2, sz-half, err2)...)
 		if err1 != nil {
-			return op1, err1
+			return res1, err1
 		} else {
-			return op1, err2
+			return res1, err2
 		}
 	}
 
 	q := string(queue)
-	mGroup := ""
-	if strings.HasSuffix(q, "fifo") == true {
-		mGroup = "default"
-	}
 
 	batch := make([]*sqs.SendMessageBatchRequestEntry, 0, sz)
+	sent := make(map[string]*sqs.SendMessageBatchRequestEntry, sz)
 
 	// make a batch of messages that we successfully processed so far
 	for ix, m := range messages {
-		if ops[ix] == true {
-			batch = append(batch, constructSend(m, ix, mGroup))
+		if results[ix].Success == true {
+			e := constructSend(m, ix, fifo, now)
+			if uint(len(e.MessageAttributes)) > MAX_SQS_ATTRIBUTE_COUNT {
+				awsi.log.Warn("too many message attributes, ignoring further processing for it", "count", len(e.MessageAttributes))
+				results[ix] = failedEntry(BatchEntryCodeTooManyAttributes, ErrTooManyAttributes.Error(), false)
+				continue
+			}
+			batch = append(batch, e)
+			sent[*e.Id] = e
 		}
 	}
 
+	// nothing left to send
+	if len(batch) == 0 {
+		return results, ErrOneOrMoreOperationsUnsuccessful
+	}
+
 	start := time.Now()
-	response, err := awsi.svc.SendMessageBatch(&sqs.SendMessageBatchInput{
+	response, err := awsi.svc.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
 		Entries:  batch,
 		QueueUrl: &q,
 	})
-	elapsed := int64(time.Since(start) / time.Millisecond)
+	elapsed := time.Since(start)
 
-	// we want to warn if the receive took a long time
-	warnIfSlow(elapsed, "SendMessageBatch")
+	event := ObserverEvent{Operation: OperationSend, Queue: queue, Latency: elapsed, Messages: uint(len(batch)), Bytes: totalSize, Err: err}
+	if response != nil {
+		event.Failures = uint(len(response.Failed))
+	}
+	awsi.observer.Observe(event)
 
 	if err != nil {
+		// unless SQS rejected the request the messages may have been enqueued
+		if requestRejected(err) == false {
+			for ix := range messages {
+				if results[ix].Success == true {
+					messages[ix].enqueued = true
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return emptyBatchResult, ctx.Err()
+		}
 		if strings.HasPrefix(err.Error(), sqs.ErrCodeQueueDoesNotExist) {
-			return emptyOpList, ErrBadQueueHandle
+			awsi.handles.invalidate(queue)
+			return emptyBatchResult, ErrBadQueueHandle
 		}
-		return emptyOpList, err
+		return emptyBatchResult, err
 	}
 
 	for _, f := range response.Failed {
-		log.Printf("WARNING: ID %s send not successful (%s)", *f.Id, *f.Message)
+		awsi.log.Warn("send not successful", "id", aws.StringValue(f.Id), "code", aws.StringValue(f.Code), "reason", aws.StringValue(f.Message))
 		id, converr := strconv.Atoi(*f.Id)
 		if converr == nil && id < sz {
-			ops[id] = false
+			results[id] = failedEntryFromAws(f)
 		}
 	}
 
-	// if any of the operation statuses are failures, return an error indicating so
-	for _, b := range ops {
-		if b == false {
-			return ops, ErrOneOrMoreOperationsUnsuccessful
+	// a message that SQS did not receive as we sent it has not been sent successfully, SQS has enqueued what
+	// it received so it is not retried (that would enqueue a duplicate) and its payload is kept
+	for _, s := range response.Successful {
+		e, found := sent[aws.StringValue(s.Id)]
+		if found == false {
+			continue
+		}
+		checkErr := verifySendChecksums(e, s)
+		if checkErr != nil {
+			awsi.log.Warn("send checksum mismatch", "id", aws.StringValue(s.Id), "error", checkErr)
+			id, _ := strconv.Atoi(*s.Id)
+			results[id] = failedEntry(BatchEntryCodeChecksumMismatch, checkErr.Error(), false)
+			messages[id].enqueued = true
 		}
 	}
 
-	return ops, nil
+	// if any of the entries are failures, return an error indicating so
+	if results.AllSuccessful() == false {
+		return results, ErrOneOrMoreOperationsUnsuccessful
+	}
+
+	return results, nil
 }
 
 // BatchMessageDelete mark a batch of messages from the specified queue as suitable for delete. This mechanism
 // prevents messages from being reprocessed.
 func (awsi *awsSqsImpl) BatchMessageDelete(queue QueueHandle, messages []Message) ([]OpStatus, error) {
+	return awsi.BatchMessageDeleteWithContext(context.Background(), queue, messages)
+}
+
+// BatchMessageDeleteWithContext mark a batch of messages from the specified queue as suitable for delete.
+// Cancelling the context abandons the delete and any delete of oversize message payloads.
+func (awsi *awsSqsImpl) BatchMessageDeleteWithContext(ctx context.Context, queue QueueHandle, messages []Message) ([]OpStatus, error) {
+	results, err := awsi.BatchMessageDeleteWithResultWithContext(ctx, queue, messages)
+	return results.OpStatus(), err
+}
+
+// BatchMessageDeleteWithResult mark a batch of messages from the specified queue as suitable for delete.
+// in the event of one or more failure, the batch result will indicate which messages were processed
+// successfully and the reason the others were not. Failures are retried according to the retry policy.
+func (awsi *awsSqsImpl) BatchMessageDeleteWithResult(queue QueueHandle, messages []Message) (BatchResult, error) {
+	return awsi.BatchMessageDeleteWithResultWithContext(context.Background(), queue, messages)
+}
+
+// BatchMessageDeleteWithResultWithContext mark a batch of messages from the specified queue as suitable for
+// delete, reporting the outcome of each. Cancelling the context abandons the delete.
+func (awsi *awsSqsImpl) BatchMessageDeleteWithResultWithContext(ctx context.Context, queue QueueHandle, messages []Message) (BatchResult, error) {
+	return awsi.batchMessageDelete(ctx, queue, messages, true)
+}
+
+// delete a batch of messages, optionally deleting the payloads of oversize messages too
+func (awsi *awsSqsImpl) batchMessageDelete(ctx context.Context, queue QueueHandle, messages []Message, deletePayloads bool) (BatchResult, error) {
+	return awsi.retryBatch(ctx, messages, func(batch []Message) (BatchResult, error) {
+		return awsi.batchMessageDeleteOnce(ctx, queue, batch, deletePayloads)
+	})
+}
+
+// make a single attempt to delete a batch of messages
+func (awsi *awsSqsImpl) batchMessageDeleteOnce(ctx context.Context, queue QueueHandle, messages []Message, deletePayloads bool) (BatchResult, error) {
 
 	// early exit if no messages provided
 	var sz = uint(len(messages))
 	if sz == 0 {
-		return emptyOpList, nil
+		return emptyBatchResult, nil
 	}
 
 	// ensure the block size is not too large
 	if sz > MAX_SQS_BLOCK_COUNT {
-		return emptyOpList, ErrBlockCountTooLarge
+		return emptyBatchResult, ErrBlockCountTooLarge
 	}
 
 	q := string(queue)
 
 	batch := make([]*sqs.DeleteMessageBatchRequestEntry, 0, sz)
-	ops := make([]OpStatus, sz)
+	results := make(BatchResult, sz)
 
 	// the SQS delete loop, initially, assume everything works
 	for ix, m := range messages {
-		ops[ix] = true
+		results[ix] = successfulEntry
 		batch = append(batch, constructDelete(m.GetReceiptHandle(), ix))
 	}
 
 	start := time.Now()
-	response, err := awsi.svc.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+	response, err := awsi.svc.DeleteMessageBatchWithContext(ctx, &sqs.DeleteMessageBatchInput{
 		Entries:  batch,
 		QueueUrl: &q,
 	})
-	elapsed := int64(time.Since(start) / time.Millisecond)
+	elapsed := time.Since(start)
 
-	// we want to warn if the receive took a long time
-	warnIfSlow(elapsed, "DeleteMessageBatch")
+	event := ObserverEvent{Operation: OperationDelete, Queue: queue, Latency: elapsed, Messages: sz, Err: err}
+	if response != nil {
+		event.Failures = uint(len(response.Failed))
+	}
+	awsi.observer.Observe(event)
 
 	if err != nil {
+		if ctx.Err() != nil {
+			return emptyBatchResult, ctx.Err()
+		}
 		if strings.HasPrefix(err.Error(), sqs.ErrCodeQueueDoesNotExist) {
-			return emptyOpList, ErrBadQueueHandle
+			awsi.handles.invalidate(queue)
+			return emptyBatchResult, ErrBadQueueHandle
 		}
-		return emptyOpList, err
+		return emptyBatchResult, err
 	}
 
 	for _, f := range response.Failed {
-		log.Printf("WARNING: ID %s delete not successful (%s)", *f.Id, *f.Message)
+		awsi.log.Warn("delete not successful", "id", aws.StringValue(f.Id), "code", aws.StringValue(f.Code), "reason", aws.StringValue(f.Message))
 		id, converr := strconv.Atoi(*f.Id)
 		if converr == nil && uint(id) < sz {
-			ops[id] = false
+			results[id] = failedEntryFromAws(f)
 		} else {
-			log.Printf("WARNING: suspect ID %s in delete response", *f.Id)
+			awsi.log.Warn("suspect ID in delete response", "id", aws.StringValue(f.Id))
 		}
 	}
 
@@ -316,15 +811,98 @@ func (awsi *awsSqsImpl) BatchMessageDelete(queue QueueHandle, messages []Message
 	for _, f := range response.Successful {
 		id, converr := strconv.Atoi(*f.Id)
 		if converr == nil && uint(id) < sz {
-			if messages[id].IsOversize() == true {
-				deleteError := messages[id].DeleteOversizeMessage()
+			if deletePayloads == true && messages[id].IsOversize() == true {
+				deleteError := messages[id].deleteOversizeMessage(ctx, awsi.storeFor(queue))
 				if deleteError != nil {
-					log.Printf("WARNING: failed deleting oversize message")
-					ops[id] = false
+					awsi.log.Warn("failed deleting oversize message", "error", deleteError)
+					// the message itself is gone so there is nothing to retry
+					results[id] = failedEntry(BatchEntryCodePayloadStoreFailure, deleteError.Error(), false)
 				}
 			}
 		} else {
-			log.Printf("WARNING: suspect ID %s in delete response", *f.Id)
+			awsi.log.Warn("suspect ID in delete response", "id", aws.StringValue(f.Id))
+		}
+	}
+
+	// if any of the entries are failures, return an error indicating so
+	if results.AllSuccessful() == false {
+		return results, ErrOneOrMoreOperationsUnsuccessful
+	}
+
+	return results, nil
+}
+
+// BatchMessageVisibilityChange change the visibility timeout of a batch of in-flight messages. The timeout
+// is measured from now and a timeout of zero makes the messages visible immediately.
+func (awsi *awsSqsImpl) BatchMessageVisibilityChange(queue QueueHandle, messages []Message, timeout time.Duration) ([]OpStatus, error) {
+	return awsi.BatchMessageVisibilityChangeWithContext(context.Background(), queue, messages, timeout)
+}
+
+// BatchMessageVisibilityChangeWithContext change the visibility timeout of a batch of in-flight messages.
+func (awsi *awsSqsImpl) BatchMessageVisibilityChangeWithContext(ctx context.Context, queue QueueHandle, messages []Message, timeout time.Duration) ([]OpStatus, error) {
+
+	// early exit if no messages provided
+	var sz = uint(len(messages))
+	if sz == 0 {
+		return emptyOpList, nil
+	}
+
+	// ensure the block size is not too large
+	if sz > MAX_SQS_BLOCK_COUNT {
+		return emptyOpList, ErrBlockCountTooLarge
+	}
+
+	// ensure the timeout is not negative or too large
+	if timeout < 0 {
+		return emptyOpList, ErrVisibilityNegative
+	}
+	if timeout.Seconds() > float64(MAX_SQS_VISIBILITY_TIMEOUT) {
+		return emptyOpList, ErrVisibilityTooLarge
+	}
+
+	q := string(queue)
+
+	batch := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, 0, sz)
+	ops := make([]OpStatus, sz)
+
+	// initially, assume everything works. Use the native receipt handle because oversize messages
+	// overload it with the payload location
+	for ix, m := range messages {
+		ops[ix] = true
+		batch = append(batch, constructChangeVisibility(m.GetReceiptHandle(), ix, timeout))
+	}
+
+	start := time.Now()
+	response, err := awsi.svc.ChangeMessageVisibilityBatchWithContext(ctx, &sqs.ChangeMessageVisibilityBatchInput{
+		Entries:  batch,
+		QueueUrl: &q,
+	})
+	elapsed := time.Since(start)
+
+	event := ObserverEvent{Operation: OperationVisibilityChange, Queue: queue, Latency: elapsed, Messages: sz, Err: err}
+	if response != nil {
+		event.Failures = uint(len(response.Failed))
+	}
+	awsi.observer.Observe(event)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return emptyOpList, ctx.Err()
+		}
+		if strings.HasPrefix(err.Error(), sqs.ErrCodeQueueDoesNotExist) {
+			awsi.handles.invalidate(queue)
+			return emptyOpList, ErrBadQueueHandle
+		}
+		return emptyOpList, err
+	}
+
+	for _, f := range response.Failed {
+		awsi.log.Warn("visibility change not successful", "id", aws.StringValue(f.Id), "code", aws.StringValue(f.Code), "reason", aws.StringValue(f.Message))
+		id, converr := strconv.Atoi(*f.Id)
+		if converr == nil && uint(id) < sz {
+			ops[id] = false
+		} else {
+			awsi.log.Warn("suspect ID in visibility change response", "id", aws.StringValue(f.Id))
 		}
 	}
 
@@ -338,50 +916,133 @@ func (awsi *awsSqsImpl) BatchMessageDelete(queue QueueHandle, messages []Message
 	return ops, nil
 }
 
-// MessagePutRetry retry a batched put after one or more of the operations fails.
-// retry the specified amount of times and return an error of after retrying one or messages
-// has still not been sent successfully.
-func (awsi *awsSqsImpl) MessagePutRetry(queue QueueHandle, messages []Message, opStatus []OpStatus, retries uint) error {
+// BatchMessageRedrive move a batch of messages received from a dead letter queue to the specified queue.
+// Messages are sent before they are deleted so a failure never loses a message but may duplicate it
+func (awsi *awsSqsImpl) BatchMessageRedrive(deadLetterQueue QueueHandle, queue QueueHandle, messages []Message) (BatchResult, error) {
+	return awsi.BatchMessageRedriveWithContext(context.Background(), deadLetterQueue, queue, messages)
+}
+
+// BatchMessageRedriveWithContext move a batch of messages received from a dead letter queue to the specified
+// queue. Cancelling the context abandons the move
+func (awsi *awsSqsImpl) BatchMessageRedriveWithContext(ctx context.Context, deadLetterQueue QueueHandle, queue QueueHandle, messages []Message) (BatchResult, error) {
 
-	// if we made it here then there is still operations outstanding and we have run out of attempts.
-	// just return an error
-	if retries == 0 {
-		log.Printf("ERROR: out of retries, giving up")
-		return ErrOneOrMoreOperationsUnsuccessful
+	// early exit if no messages provided
+	var sz = uint(len(messages))
+	if sz == 0 {
+		return emptyBatchResult, nil
 	}
 
-	// create the retry batch
-	retryBatch := make([]Message, 0)
-	for ix, op := range opStatus {
-		if op == false {
-			retryBatch = append(retryBatch, messages[ix])
-		}
+	// ensure the block size is not too large
+	if sz > MAX_SQS_BLOCK_COUNT {
+		return emptyBatchResult, ErrBlockCountTooLarge
 	}
 
-	// make sure there are items to retry... if not return success
-	sz := len(retryBatch)
-	if sz == 0 {
-		return nil
+	results := make(BatchResult, sz)
+
+	// make the messages to send, remembering which message each one came from. We cannot
+	// send incomplete messages because we do not have their content
+	outbound := make([]Message, 0, sz)
+	sources := make([]int, 0, sz)
+	for ix := range messages {
+		if messages[ix].Incomplete == true {
+			results[ix] = failedEntry(BatchEntryCodeIncompleteMessage, "incomplete messages cannot be redriven", false)
+			continue
+		}
+		outbound = append(outbound, messages[ix].redriveClone())
+		sources = append(sources, ix)
 	}
 
-	// sleep for a while
-	time.Sleep(100 * time.Millisecond)
+	if len(outbound) != 0 {
+		sent, err := awsi.BatchMessagePutWithResultWithContext(ctx, queue, outbound)
+		if err != nil && err != ErrOneOrMoreOperationsUnsuccessful {
+			return emptyBatchResult, err
+		}
 
-	log.Printf("INFO: retrying %d item(s)... (%d remaining tries)", sz, retries)
+		// delete the messages that were sent, leaving their oversize payloads in place
+		toDelete := make([]Message, 0, len(outbound))
+		deleteSources := make([]int, 0, len(outbound))
+		for ox, r := range sent {
+			results[sources[ox]] = r
+			if r.Success == true {
+				toDelete = append(toDelete, messages[sources[ox]])
+				deleteSources = append(deleteSources, sources[ox])
+			}
+		}
 
-	opStatusRetry, err := awsi.BatchMessagePut(queue, retryBatch)
-	// if success then we are done
-	if err == nil {
-		return nil
+		if len(toDelete) != 0 {
+			deleted, err := awsi.batchMessageDelete(ctx, deadLetterQueue, toDelete, false)
+			for dx, ix := range deleteSources {
+				if err != nil && err != ErrOneOrMoreOperationsUnsuccessful {
+					results[ix] = failedEntry(BatchEntryCodeNotDeleted, err.Error(), false)
+				} else if deleted[dx].Success == false {
+					results[ix] = failedEntry(BatchEntryCodeNotDeleted, deleted[dx].Message, false)
+				}
+			}
+		}
 	}
 
-	// if not success, anything other than an error we can retry is fatal so give up
-	if err != ErrOneOrMoreOperationsUnsuccessful {
-		return err
+	// if any of the entries are failures, return an error indicating so
+	if results.AllSuccessful() == false {
+		return results, ErrOneOrMoreOperationsUnsuccessful
 	}
 
-	// try again and reduce the retries count
-	return awsi.MessagePutRetry(queue, retryBatch, opStatusRetry, retries-1)
+	return results, nil
+}
+
+// MessagePutRetry retry a batched put after one or more of the operations fails.
+// retry the specified amount of times and return an error of after retrying one or messages
+// has still not been sent successfully. Each retry is itself retried according to the retry policy.
+func (awsi *awsSqsImpl) MessagePutRetry(queue QueueHandle, messages []Message, opStatus []OpStatus, retries uint) error {
+	return awsi.MessagePutRetryWithContext(context.Background(), queue, messages, opStatus, retries)
+}
+
+// MessagePutRetryWithContext retry a batched put after one or more of the operations fails. Retries are
+// delayed according to the retry policy. Cancelling the context abandons any remaining retries.
+func (awsi *awsSqsImpl) MessagePutRetryWithContext(ctx context.Context, queue QueueHandle, messages []Message, opStatus []OpStatus, retries uint) error {
+
+	for attempt := uint(1); ; attempt++ {
+
+		// create the retry batch
+		retryBatch := make([]Message, 0)
+		for ix, op := range opStatus {
+			if op == false {
+				retryBatch = append(retryBatch, messages[ix])
+			}
+		}
+
+		// make sure there are items to retry... if not return success
+		sz := len(retryBatch)
+		if sz == 0 {
+			return nil
+		}
+
+		// if we made it here then there is still operations outstanding and we have run out of attempts.
+		// just return an error
+		if attempt > retries {
+			awsi.log.Error("out of retries, giving up")
+			return ErrOneOrMoreOperationsUnsuccessful
+		}
+
+		// wait for a while
+		if err := awsi.retry.wait(ctx, attempt); err != nil {
+			return err
+		}
+
+		awsi.log.Info("retrying", "items", sz, "remaining", retries-attempt+1)
+
+		var err error
+		opStatus, err = awsi.BatchMessagePutWithContext(ctx, queue, retryBatch)
+		// if success then we are done
+		if err == nil {
+			return nil
+		}
+
+		// if not success, anything other than an error we can retry is fatal so give up
+		if err != ErrOneOrMoreOperationsUnsuccessful {
+			return err
+		}
+		messages = retryBatch
+	}
 }
 
 //
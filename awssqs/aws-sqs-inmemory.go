@@ -0,0 +1,794 @@
+package awssqs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/google/uuid"
+)
+
+// the bucket name used for oversize messages by the in-memory implementation
+var inMemoryMessageBucketName = "in-memory-messages"
+
+// the prefix applied to in-memory queue names to make a queue handle (URL) and a queue ARN
+var inMemoryQueueUrlPrefix = "https://sqs.in-memory.local/000000000000/"
+var inMemoryQueueArnPrefix = "arn:aws:sqs:in-memory:000000000000:"
+
+// the default visibility timeout for in-memory queues (the same as the SQS default)
+var inMemoryDefaultVisibilityTimeout = 30 * time.Second
+
+// the window within which duplicate messages are discarded on FIFO queues (the same as SQS)
+var inMemoryDeduplicationWindow = 5 * time.Minute
+
+// how often a waiting receive checks for messages that have become visible again
+var inMemoryPollInterval = 50 * time.Millisecond
+
+// a single message held in an in-memory queue
+type inMemoryMessage struct {
+	id             string
+	body           string
+	attributes     map[string]*sqs.MessageAttributeValue
+	groupId        string
+	dedupId        string
+	sequenceNumber string
+	sent           time.Time
+	firstReceived  time.Time
+	receiveCount   int
+	receiptHandle  string
+	visibleAt      time.Time
+}
+
+// an in-memory queue
+type inMemoryQueue struct {
+	name              string
+	url               string
+	fifo              bool
+	contentDedup      bool
+	visibilityTimeout time.Duration
+	sequence          uint64
+	messages          []*inMemoryMessage
+	dedupSeen         map[string]time.Time
+	attributes        map[string]string // other attributes, stored but not interpreted
+}
+
+// our fake of the SQS service, only the methods we use are implemented. The embedded interface is
+// never set so calling anything else panics which is exactly what we want
+type inMemorySqsService struct {
+	sqsiface.SQSAPI
+
+	mu      sync.Mutex
+	queues  map[string]*inMemoryQueue // keyed by queue URL
+	changed chan struct{}             // closed (and replaced) whenever messages may have become available
+	now     func() time.Time          // replaceable for testing
+}
+
+// factory for the in-memory implementation of our SQS interface
+func newInMemorySqs(queueNames []string) AWS_SQS_Context {
+
+	svc := &inMemorySqsService{
+		queues:  make(map[string]*inMemoryQueue),
+		changed: make(chan struct{}),
+		now:     time.Now,
+	}
+
+	for _, name := range queueNames {
+		svc.createQueue(name)
+	}
+
+	store := newMemoryPayloadStore()
+	store.now = svc.clock
+	config := AwsSqsConfig{MessageBucketName: inMemoryMessageBucketName, PayloadStore: store}
+	return &awsSqsImpl{config: config, svc: svc, store: store, log: defaultLogger, observer: newSlowRequestObserver(defaultLogger),
+		handles: newQueueHandleCache(), retry: noRetryPolicy, now: svc.clock}
+}
+
+// GetQueueUrlWithContext get the queue URL when provided the queue name
+func (mem *inMemorySqsService) GetQueueUrlWithContext(ctx aws.Context, input *sqs.GetQueueUrlInput, opts ...request.Option) (*sqs.GetQueueUrlOutput, error) {
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	url := inMemoryQueueUrlPrefix + aws.StringValue(input.QueueName)
+	_, err := mem.lookupQueue(url)
+	if err != nil {
+		return nil, err
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(url)}, nil
+}
+
+// the current time according to the service
+func (mem *inMemorySqsService) clock() time.Time {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	return mem.now()
+}
+
+// GetQueueAttributesWithContext get the requested attributes of the specified queue
+func (mem *inMemorySqsService) GetQueueAttributesWithContext(ctx aws.Context, input *sqs.GetQueueAttributesInput, opts ...request.Option) (*sqs.GetQueueAttributesOutput, error) {
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	queue, err := mem.lookupQueue(aws.StringValue(input.QueueUrl))
+	if err != nil {
+		return nil, err
+	}
+
+	now := mem.now()
+	visible, notVisible, delayed := 0, 0, 0
+	for _, m := range queue.messages {
+		if m.visibleAt.After(now) == false {
+			visible++
+		} else if m.receiveCount == 0 {
+			delayed++
+		} else {
+			notVisible++
+		}
+	}
+
+	all := map[string]string{
+		sqs.QueueAttributeNameApproximateNumberOfMessages:           strconv.Itoa(visible),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible: strconv.Itoa(notVisible),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesDelayed:    strconv.Itoa(delayed),
+		sqs.QueueAttributeNameVisibilityTimeout:                     strconv.Itoa(int(queue.visibilityTimeout.Seconds())),
+		sqs.QueueAttributeNameMaximumMessageSize:                    strconv.Itoa(int(MAX_SQS_MESSAGE_SIZE)),
+		sqs.QueueAttributeNameQueueArn:                              inMemoryQueueArnPrefix + queue.name,
+	}
+	for k, v := range queue.attributes {
+		all[k] = v
+	}
+	if queue.fifo == true {
+		all[sqs.QueueAttributeNameFifoQueue] = "true"
+		all[sqs.QueueAttributeNameContentBasedDeduplication] = strconv.FormatBool(queue.contentDedup)
+	}
+
+	return &sqs.GetQueueAttributesOutput{Attributes: selectAttributes(all, input.AttributeNames)}, nil
+}
+
+// ReceiveMessageWithContext receive up to the requested number of messages, waiting if necessary
+func (mem *inMemorySqsService) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+
+	maxMessages := int(aws.Int64Value(input.MaxNumberOfMessages))
+	if maxMessages == 0 {
+		maxMessages = 1
+	}
+	deadline := time.Now().Add(time.Duration(aws.Int64Value(input.WaitTimeSeconds)) * time.Second)
+
+	for {
+		mem.mu.Lock()
+		queue, err := mem.lookupQueue(aws.StringValue(input.QueueUrl))
+		if err != nil {
+			mem.mu.Unlock()
+			return nil, err
+		}
+
+		visibility := queue.visibilityTimeout
+		if input.VisibilityTimeout != nil {
+			visibility = time.Duration(*input.VisibilityTimeout) * time.Second
+		}
+
+		messages := mem.receiveFrom(queue, maxMessages, visibility, input.AttributeNames, input.MessageAttributeNames)
+		changed := mem.changed
+		mem.mu.Unlock()
+
+		// return as soon as we have any messages or when we have waited long enough
+		remaining := time.Until(deadline)
+		if len(messages) != 0 || remaining <= 0 {
+			return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+		}
+
+		// wait for new messages or for in-flight messages to become visible again
+		if remaining > inMemoryPollInterval {
+			remaining = inMemoryPollInterval
+		}
+		select {
+		case <-ctx.Done():
+			return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+		case <-changed:
+		case <-time.After(remaining):
+		}
+	}
+}
+
+// SendMessageBatchWithContext add a batch of messages to the specified queue
+func (mem *inMemorySqsService) SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	queue, err := mem.lookupQueue(aws.StringValue(input.QueueUrl))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(input.Entries) > int(MAX_SQS_BLOCK_COUNT) {
+		return nil, awserr.New(sqs.ErrCodeTooManyEntriesInBatchRequest, "too many entries in batch request", nil)
+	}
+
+	total := 0
+	for _, e := range input.Entries {
+		total += len(aws.StringValue(e.MessageBody))
+	}
+	if total > int(MAX_SQS_BLOCK_SIZE) {
+		return nil, awserr.New(sqs.ErrCodeBatchRequestTooLong, "batch request is too long", nil)
+	}
+
+	now := mem.now()
+	output := &sqs.SendMessageBatchOutput{}
+	added := false
+	for _, e := range input.Entries {
+
+		// FIFO queues require a message group
+		if queue.fifo == true && len(aws.StringValue(e.MessageGroupId)) == 0 {
+			output.Failed = append(output.Failed, batchFailure(e.Id, "MissingParameter", "message group id is required for FIFO queues"))
+			continue
+		}
+
+		// FIFO queues do not support per-message delays
+		delay := aws.Int64Value(e.DelaySeconds)
+		if delay < 0 || delay > int64(MAX_SQS_DELAY) || (queue.fifo == true && delay != 0) {
+			output.Failed = append(output.Failed, batchFailure(e.Id, "InvalidParameterValue", "the message delay is not valid"))
+			continue
+		}
+
+		m := &inMemoryMessage{
+			id:         uuid.New().String(),
+			body:       aws.StringValue(e.MessageBody),
+			attributes: e.MessageAttributes,
+			groupId:    aws.StringValue(e.MessageGroupId),
+			dedupId:    aws.StringValue(e.MessageDeduplicationId),
+			sent:       now,
+			visibleAt:  now.Add(time.Duration(delay) * time.Second),
+		}
+
+		result := &sqs.SendMessageBatchResultEntry{Id: e.Id, MessageId: aws.String(m.id), MD5OfMessageBody: aws.String(md5Hex([]byte(m.body)))}
+		if len(m.attributes) != 0 {
+			result.MD5OfMessageAttributes = aws.String(attributesMD5(m.attributes))
+		}
+
+		if queue.fifo == true {
+			if len(m.dedupId) == 0 {
+				if queue.contentDedup == false {
+					output.Failed = append(output.Failed, batchFailure(e.Id, "InvalidParameterValue", "deduplication id is required for this FIFO queue"))
+					continue
+				}
+				m.dedupId = fmt.Sprintf("%x", sha256.Sum256([]byte(m.body)))
+			}
+
+			// a duplicate within the deduplication window is reported as sent but not enqueued
+			if seen, found := queue.dedupSeen[m.dedupId]; found == true && now.Sub(seen) < inMemoryDeduplicationWindow {
+				output.Successful = append(output.Successful, result)
+				continue
+			}
+			queue.dedupSeen[m.dedupId] = now
+
+			queue.sequence++
+			m.sequenceNumber = fmt.Sprintf("%020d", queue.sequence)
+			result.SequenceNumber = aws.String(m.sequenceNumber)
+		}
+
+		queue.messages = append(queue.messages, m)
+		output.Successful = append(output.Successful, result)
+		added = true
+	}
+
+	if added == true {
+		mem.notify()
+	}
+	return output, nil
+}
+
+// DeleteMessageBatchWithContext delete a batch of messages from the specified queue
+func (mem *inMemorySqsService) DeleteMessageBatchWithContext(ctx aws.Context, input *sqs.DeleteMessageBatchInput, opts ...request.Option) (*sqs.DeleteMessageBatchOutput, error) {
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	queue, err := mem.lookupQueue(aws.StringValue(input.QueueUrl))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(input.Entries) > int(MAX_SQS_BLOCK_COUNT) {
+		return nil, awserr.New(sqs.ErrCodeTooManyEntriesInBatchRequest, "too many entries in batch request", nil)
+	}
+
+	output := &sqs.DeleteMessageBatchOutput{}
+	for _, e := range input.Entries {
+		ix := queue.findByReceipt(aws.StringValue(e.ReceiptHandle))
+		if ix < 0 {
+			output.Failed = append(output.Failed, batchFailure(e.Id, sqs.ErrCodeReceiptHandleIsInvalid, "the receipt handle is not valid"))
+			continue
+		}
+		queue.messages = append(queue.messages[:ix], queue.messages[ix+1:]...)
+		output.Successful = append(output.Successful, &sqs.DeleteMessageBatchResultEntry{Id: e.Id})
+	}
+
+	return output, nil
+}
+
+// ChangeMessageVisibilityBatchWithContext change the visibility timeout of a batch of in-flight messages
+func (mem *inMemorySqsService) ChangeMessageVisibilityBatchWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityBatchInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	queue, err := mem.lookupQueue(aws.StringValue(input.QueueUrl))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(input.Entries) > int(MAX_SQS_BLOCK_COUNT) {
+		return nil, awserr.New(sqs.ErrCodeTooManyEntriesInBatchRequest, "too many entries in batch request", nil)
+	}
+
+	now := mem.now()
+	output := &sqs.ChangeMessageVisibilityBatchOutput{}
+	changed := false
+	for _, e := range input.Entries {
+		ix := queue.findByReceipt(aws.StringValue(e.ReceiptHandle))
+		if ix < 0 {
+			output.Failed = append(output.Failed, batchFailure(e.Id, sqs.ErrCodeReceiptHandleIsInvalid, "the receipt handle is not valid"))
+			continue
+		}
+		m := queue.messages[ix]
+		if m.visibleAt.After(now) == false || m.receiveCount == 0 {
+			output.Failed = append(output.Failed, batchFailure(e.Id, sqs.ErrCodeMessageNotInflight, "the message is not in flight"))
+			continue
+		}
+		m.visibleAt = now.Add(time.Duration(aws.Int64Value(e.VisibilityTimeout)) * time.Second)
+		changed = true
+		output.Successful = append(output.Successful, &sqs.ChangeMessageVisibilityBatchResultEntry{Id: e.Id})
+	}
+
+	// messages may have become visible
+	if changed == true {
+		mem.notify()
+	}
+	return output, nil
+}
+
+// CreateQueueWithContext create a queue, creating an existing queue with the same attributes is not an error
+func (mem *inMemorySqsService) CreateQueueWithContext(ctx aws.Context, input *sqs.CreateQueueInput, opts ...request.Option) (*sqs.CreateQueueOutput, error) {
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	name := aws.StringValue(input.QueueName)
+	attributes := aws.StringValueMap(input.Attributes)
+	fifo := attributes[sqs.QueueAttributeNameFifoQueue] == "true"
+	if len(name) == 0 || fifo != strings.HasSuffix(name, fifoQueueSuffix) {
+		return nil, awserr.New("InvalidParameterValue", "the queue name is not valid", nil)
+	}
+	delete(attributes, sqs.QueueAttributeNameFifoQueue)
+
+	url := inMemoryQueueUrlPrefix + name
+	existing, found := mem.queues[url]
+	if found == true {
+		if existing.hasAttributes(attributes) == false {
+			return nil, awserr.New(sqs.ErrCodeQueueNameExists, "a queue with this name already exists with different attributes", nil)
+		}
+		return &sqs.CreateQueueOutput{QueueUrl: aws.String(url)}, nil
+	}
+
+	queue := newInMemoryQueue(name, fifo)
+	err := queue.applyAttributes(attributes)
+	if err != nil {
+		return nil, err
+	}
+	mem.queues[url] = queue
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String(url)}, nil
+}
+
+// DeleteQueueWithContext delete a queue and its messages
+func (mem *inMemorySqsService) DeleteQueueWithContext(ctx aws.Context, input *sqs.DeleteQueueInput, opts ...request.Option) (*sqs.DeleteQueueOutput, error) {
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	queue, err := mem.lookupQueue(aws.StringValue(input.QueueUrl))
+	if err != nil {
+		return nil, err
+	}
+	delete(mem.queues, queue.url)
+
+	// anybody waiting on this queue will find it has gone
+	mem.notify()
+	return &sqs.DeleteQueueOutput{}, nil
+}
+
+// PurgeQueueWithContext delete all the messages in a queue
+func (mem *inMemorySqsService) PurgeQueueWithContext(ctx aws.Context, input *sqs.PurgeQueueInput, opts ...request.Option) (*sqs.PurgeQueueOutput, error) {
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	queue, err := mem.lookupQueue(aws.StringValue(input.QueueUrl))
+	if err != nil {
+		return nil, err
+	}
+	queue.messages = nil
+	return &sqs.PurgeQueueOutput{}, nil
+}
+
+// ListQueuesWithContext list the queues with the name prefix in name order, paginated only if a maximum is supplied
+func (mem *inMemorySqsService) ListQueuesWithContext(ctx aws.Context, input *sqs.ListQueuesInput, opts ...request.Option) (*sqs.ListQueuesOutput, error) {
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	prefix := aws.StringValue(input.QueueNamePrefix)
+	names := make([]string, 0, len(mem.queues))
+	for _, q := range mem.queues {
+		if strings.HasPrefix(q.name, prefix) == true {
+			names = append(names, q.name)
+		}
+	}
+	sort.Strings(names)
+
+	// the token is the index of the first queue in the page
+	start := 0
+	if input.NextToken != nil {
+		ix, err := strconv.Atoi(aws.StringValue(input.NextToken))
+		if err != nil || ix < 0 || ix > len(names) {
+			return nil, awserr.New("InvalidParameterValue", "the next token is not valid", nil)
+		}
+		start = ix
+	}
+	end := len(names)
+	if input.MaxResults != nil && start+int(*input.MaxResults) < end {
+		end = start + int(*input.MaxResults)
+	}
+
+	output := &sqs.ListQueuesOutput{}
+	for _, name := range names[start:end] {
+		output.QueueUrls = append(output.QueueUrls, aws.String(inMemoryQueueUrlPrefix+name))
+	}
+	if input.MaxResults != nil && end < len(names) {
+		output.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return output, nil
+}
+
+// ListDeadLetterSourceQueuesWithContext list the queues that use the specified queue as their dead letter queue
+func (mem *inMemorySqsService) ListDeadLetterSourceQueuesWithContext(ctx aws.Context, input *sqs.ListDeadLetterSourceQueuesInput, opts ...request.Option) (*sqs.ListDeadLetterSourceQueuesOutput, error) {
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	deadLetter, err := mem.lookupQueue(aws.StringValue(input.QueueUrl))
+	if err != nil {
+		return nil, err
+	}
+
+	output := &sqs.ListDeadLetterSourceQueuesOutput{QueueUrls: make([]*string, 0)}
+	for _, q := range mem.queues {
+		if mem.deadLetterQueueFor(q) == deadLetter {
+			output.QueueUrls = append(output.QueueUrls, aws.String(q.url))
+		}
+	}
+	return output, nil
+}
+
+// SetQueueAttributesWithContext set the supplied queue attributes
+func (mem *inMemorySqsService) SetQueueAttributesWithContext(ctx aws.Context, input *sqs.SetQueueAttributesInput, opts ...request.Option) (*sqs.SetQueueAttributesOutput, error) {
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	queue, err := mem.lookupQueue(aws.StringValue(input.QueueUrl))
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := aws.StringValueMap(input.Attributes)
+	if _, found := attributes[sqs.QueueAttributeNameFifoQueue]; found == true {
+		return nil, awserr.New(sqs.ErrCodeInvalidAttributeName, "the queue type cannot be changed", nil)
+	}
+	err = queue.applyAttributes(attributes)
+	if err != nil {
+		return nil, err
+	}
+	return &sqs.SetQueueAttributesOutput{}, nil
+}
+
+//
+// implementation methods
+//
+
+// create a queue, the visibility timeout and deduplication settings are the SQS defaults
+// (except FIFO queues use content based deduplication by default)
+func (mem *inMemorySqsService) createQueue(name string) *inMemoryQueue {
+
+	url := inMemoryQueueUrlPrefix + name
+	queue, found := mem.queues[url]
+	if found == true {
+		return queue
+	}
+
+	fifo := strings.HasSuffix(name, fifoQueueSuffix)
+	queue = newInMemoryQueue(name, fifo)
+	queue.contentDedup = fifo
+	mem.queues[url] = queue
+	return queue
+}
+
+// make a new empty queue with the SQS default attributes
+func newInMemoryQueue(name string, fifo bool) *inMemoryQueue {
+
+	return &inMemoryQueue{
+		name:              name,
+		url:               inMemoryQueueUrlPrefix + name,
+		fifo:              fifo,
+		visibilityTimeout: inMemoryDefaultVisibilityTimeout,
+		dedupSeen:         make(map[string]time.Time),
+		attributes:        make(map[string]string),
+	}
+}
+
+// apply the supplied attributes, those we do not interpret are just stored
+func (queue *inMemoryQueue) applyAttributes(attributes map[string]string) error {
+
+	for k, v := range attributes {
+		switch k {
+		case sqs.QueueAttributeNameVisibilityTimeout:
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds < 0 || uint(seconds) > MAX_SQS_VISIBILITY_TIMEOUT {
+				return awserr.New("InvalidAttributeValue", "the visibility timeout is not valid", nil)
+			}
+			queue.visibilityTimeout = time.Duration(seconds) * time.Second
+
+		case sqs.QueueAttributeNameContentBasedDeduplication:
+			dedup, err := strconv.ParseBool(v)
+			if err != nil || queue.fifo == false {
+				return awserr.New(sqs.ErrCodeInvalidAttributeName, "content based deduplication is only valid for FIFO queues", nil)
+			}
+			queue.contentDedup = dedup
+
+		case sqs.QueueAttributeNameRedrivePolicy:
+			if len(v) == 0 {
+				delete(queue.attributes, k)
+				continue
+			}
+			_, _, err := parseRedrivePolicy(v)
+			if err != nil {
+				return awserr.New("InvalidAttributeValue", "the redrive policy is not valid", nil)
+			}
+			queue.attributes[k] = v
+
+		default:
+			queue.attributes[k] = v
+		}
+	}
+	return nil
+}
+
+// does the queue have the supplied attribute values
+func (queue *inMemoryQueue) hasAttributes(attributes map[string]string) bool {
+
+	for k, v := range attributes {
+		switch k {
+		case sqs.QueueAttributeNameVisibilityTimeout:
+			if v != strconv.Itoa(int(queue.visibilityTimeout.Seconds())) {
+				return false
+			}
+		case sqs.QueueAttributeNameContentBasedDeduplication:
+			if v != strconv.FormatBool(queue.contentDedup) {
+				return false
+			}
+		default:
+			if queue.attributes[k] != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// find the queue with the supplied URL, must be called with the lock held
+func (mem *inMemorySqsService) lookupQueue(url string) (*inMemoryQueue, error) {
+
+	queue, found := mem.queues[url]
+	if found == false {
+		return nil, awserr.New(sqs.ErrCodeQueueDoesNotExist, "the specified queue does not exist", nil)
+	}
+	return queue, nil
+}
+
+// wake up anybody waiting for messages, must be called with the lock held
+func (mem *inMemorySqsService) notify() {
+	close(mem.changed)
+	mem.changed = make(chan struct{})
+}
+
+// select and mark as in-flight up to the maximum number of available messages, must be called with the lock held
+func (mem *inMemorySqsService) receiveFrom(queue *inMemoryQueue, maxMessages int, visibility time.Duration, attribNames []*string, messageAttribNames []*string) []*sqs.Message {
+
+	now := mem.now()
+	result := make([]*sqs.Message, 0, maxMessages)
+
+	// messages received too many times are not received again
+	mem.moveToDeadLetterQueue(queue, now)
+
+	// FIFO queues never deliver a message while an earlier message in the same group is in flight
+	blockedGroups := make(map[string]bool)
+
+	for _, m := range queue.messages {
+		if len(result) == maxMessages {
+			break
+		}
+
+		if queue.fifo == true && blockedGroups[m.groupId] == true {
+			continue
+		}
+
+		if m.visibleAt.After(now) {
+			if queue.fifo == true {
+				blockedGroups[m.groupId] = true
+			}
+			continue
+		}
+
+		m.receiveCount++
+		if m.firstReceived.IsZero() {
+			m.firstReceived = now
+		}
+		m.receiptHandle = uuid.New().String()
+		m.visibleAt = now.Add(visibility)
+
+		result = append(result, m.makeAwsMessage(queue, attribNames, messageAttribNames))
+	}
+
+	return result
+}
+
+// get the dead letter queue of the supplied queue, nil if it does not have one, must be called with the lock held
+func (mem *inMemorySqsService) deadLetterQueueFor(queue *inMemoryQueue) *inMemoryQueue {
+
+	policy := queue.attributes[sqs.QueueAttributeNameRedrivePolicy]
+	if len(policy) == 0 {
+		return nil
+	}
+	arn, _, err := parseRedrivePolicy(policy)
+	if err != nil {
+		return nil
+	}
+	return mem.queues[inMemoryQueueUrlPrefix+queueNameFromArn(arn)]
+}
+
+// move the available messages that have reached the maximum receive count to the dead letter queue (if
+// there is one), must be called with the lock held
+func (mem *inMemorySqsService) moveToDeadLetterQueue(queue *inMemoryQueue, now time.Time) {
+
+	deadLetter := mem.deadLetterQueueFor(queue)
+	if deadLetter == nil || deadLetter == queue {
+		return
+	}
+	_, maxReceiveCount, _ := parseRedrivePolicy(queue.attributes[sqs.QueueAttributeNameRedrivePolicy])
+
+	kept := make([]*inMemoryMessage, 0, len(queue.messages))
+	for _, m := range queue.messages {
+		if m.visibleAt.After(now) == true || uint(m.receiveCount) < maxReceiveCount {
+			kept = append(kept, m)
+			continue
+		}
+
+		// the message starts again in the dead letter queue
+		m.receiveCount = 0
+		m.firstReceived = time.Time{}
+		m.receiptHandle = ""
+		m.visibleAt = now
+		if deadLetter.fifo == true {
+			deadLetter.sequence++
+			m.sequenceNumber = fmt.Sprintf("%020d", deadLetter.sequence)
+		}
+		deadLetter.messages = append(deadLetter.messages, m)
+	}
+	queue.messages = kept
+}
+
+// find the index of the message with the supplied receipt handle, -1 if not found
+func (queue *inMemoryQueue) findByReceipt(receiptHandle string) int {
+
+	if len(receiptHandle) == 0 {
+		return -1
+	}
+	for ix, m := range queue.messages {
+		if m.receiptHandle == receiptHandle {
+			return ix
+		}
+	}
+	return -1
+}
+
+// make the AWS message structure for a received message
+func (m *inMemoryMessage) makeAwsMessage(queue *inMemoryQueue, attribNames []*string, messageAttribNames []*string) *sqs.Message {
+
+	all := map[string]string{
+		sqs.MessageSystemAttributeNameSentTimestamp:                    strconv.FormatInt(m.sent.UnixNano()/int64(time.Millisecond), 10),
+		sqs.MessageSystemAttributeNameApproximateFirstReceiveTimestamp: strconv.FormatInt(m.firstReceived.UnixNano()/int64(time.Millisecond), 10),
+		sqs.MessageSystemAttributeNameApproximateReceiveCount:          strconv.Itoa(m.receiveCount),
+	}
+	if queue.fifo == true {
+		all[sqs.MessageSystemAttributeNameMessageGroupId] = m.groupId
+		all[sqs.MessageSystemAttributeNameMessageDeduplicationId] = m.dedupId
+		all[sqs.MessageSystemAttributeNameSequenceNumber] = m.sequenceNumber
+	}
+
+	attributes := make(map[string]*string)
+	for k, v := range selectAttributes(all, attribNames) {
+		attributes[k] = v
+	}
+
+	messageAttributes := make(map[string]*sqs.MessageAttributeValue)
+	names := aws.StringValueSlice(messageAttribNames)
+	for k, v := range m.attributes {
+		if attributeRequested(k, names) == true {
+			messageAttributes[k] = v
+		}
+	}
+
+	message := &sqs.Message{
+		MessageId:         aws.String(m.id),
+		ReceiptHandle:     aws.String(m.receiptHandle),
+		Body:              aws.String(m.body),
+		MD5OfBody:         aws.String(md5Hex([]byte(m.body))),
+		Attributes:        attributes,
+		MessageAttributes: messageAttributes,
+	}
+	if len(messageAttributes) != 0 {
+		message.MD5OfMessageAttributes = aws.String(attributesMD5(messageAttributes))
+	}
+	return message
+}
+
+// select the requested attributes from the complete set
+func selectAttributes(all map[string]string, names []*string) map[string]*string {
+
+	requested := aws.StringValueSlice(names)
+	selected := make(map[string]*string)
+	for k, v := range all {
+		if attributeRequested(k, requested) == true {
+			selected[k] = aws.String(v)
+		}
+	}
+	return selected
+}
+
+// is the named attribute in the requested set (taking into account the wildcard forms)
+func attributeRequested(name string, requested []string) bool {
+
+	for _, r := range requested {
+		if r == sqs.QueueAttributeNameAll || r == ".*" || r == name {
+			return true
+		}
+		if strings.HasSuffix(r, ".*") && strings.HasPrefix(name, strings.TrimSuffix(r, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// make a batch failure entry
+func batchFailure(id *string, code string, message string) *sqs.BatchResultErrorEntry {
+	return &sqs.BatchResultErrorEntry{
+		Id:          id,
+		Code:        aws.String(code),
+		Message:     aws.String(message),
+		SenderFault: aws.Bool(true),
+	}
+}
+
+//
+// end of file
+//
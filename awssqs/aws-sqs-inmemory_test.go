@@ -0,0 +1,424 @@
+package awssqs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+var inMemoryQueueName = "virgo4-ingest-test-in-memory"
+var inMemoryFifoQueueName = "virgo4-ingest-test-in-memory.fifo"
+
+//
+// In-memory implementation behavior tests
+//
+
+func TestInMemoryCorrectMessageContent(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, err := awssqs.QueueHandle(inMemoryQueueName)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	count := MAX_SQS_BLOCK_COUNT
+	messages := makeSmallMessages(count)
+	ops, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if allOperationsOK(ops) == false {
+		t.Fatalf("One or more put operations reported failed incorrectly\n")
+	}
+
+	available, err := awssqs.GetMessagesAvailable(inMemoryQueueName)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if available != count {
+		t.Fatalf("Unexpected available count. Expected %d, got %d\n", count, available)
+	}
+
+	messages = exactMessageGet(t, awssqs, queueHandle, count, goodWaitTime)
+	if uint(len(messages)) != count {
+		t.Fatalf("Received a different number of messages than expected (expected: %d, received: %d)\n", count, len(messages))
+	}
+	verifyMessages(t, messages)
+
+	ops, err = awssqs.BatchMessageDelete(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if allOperationsOK(ops) == false {
+		t.Fatalf("One or more delete operations reported failed unexpectedly\n")
+	}
+
+	available, _ = awssqs.GetMessagesAvailable(inMemoryQueueName)
+	if available != 0 {
+		t.Fatalf("Expected an empty queue, found %d message(s)\n", available)
+	}
+}
+
+func TestInMemoryCorrectLargeMessageContent(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	count := uint(3)
+	messages := makeLargeMessages(count)
+	ops, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if allOperationsOK(ops) == false {
+		t.Fatalf("One or more put operations reported failed incorrectly\n")
+	}
+
+	messages = exactMessageGet(t, awssqs, queueHandle, count, goodWaitTime)
+	if uint(len(messages)) != count {
+		t.Fatalf("Received a different number of messages than expected (expected: %d, received: %d)\n", count, len(messages))
+	}
+	verifyMessages(t, messages)
+
+	for _, m := range messages {
+		if m.IsOversize() == false {
+			t.Fatalf("Expected an oversize message\n")
+		}
+	}
+
+	ops, err = awssqs.BatchMessageDelete(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if allOperationsOK(ops) == false {
+		t.Fatalf("One or more delete operations reported failed unexpectedly\n")
+	}
+
+	// deleting the messages also removes the oversize payloads
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	if store.count() != 0 {
+		t.Fatalf("Expected no oversize payloads, found %d\n", store.count())
+	}
+}
+
+func TestInMemoryVisibilityTimeout(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeStandardMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	first, _ := awssqs.BatchMessageGet(queueHandle, 1, zeroWaitTime)
+	if len(first) != 1 || first[0].ReceiveCount != 1 {
+		t.Fatalf("Expected one message received once\n")
+	}
+
+	// in flight so not visible
+	again, _ := awssqs.BatchMessageGet(queueHandle, 1, zeroWaitTime)
+	if len(again) != 0 {
+		t.Fatalf("Received an in-flight message\n")
+	}
+
+	// once the visibility timeout expires, the message is delivered again
+	clock.advance(inMemoryDefaultVisibilityTimeout)
+	again, _ = awssqs.BatchMessageGet(queueHandle, 1, zeroWaitTime)
+	if len(again) != 1 || again[0].ReceiveCount != 2 {
+		t.Fatalf("Expected the message to be redelivered\n")
+	}
+
+	// the original receipt handle is no longer valid
+	ops, err := awssqs.BatchMessageDelete(queueHandle, first)
+	if err != ErrOneOrMoreOperationsUnsuccessful || ops[0] == true {
+		t.Fatalf("Delete with a stale receipt handle reported success incorrectly\n")
+	}
+
+	ops, err = awssqs.BatchMessageDelete(queueHandle, again)
+	if err != nil || allOperationsOK(ops) == false {
+		t.Fatalf("One or more delete operations reported failed unexpectedly\n")
+	}
+}
+
+func TestInMemoryFifoOrdering(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryFifoQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryFifoQueueName)
+
+	count := MAX_SQS_BLOCK_COUNT
+	messages := makeSmallMessages(count)
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// a single message from the group is in flight so nothing else is delivered
+	first, _ := awssqs.BatchMessageGet(queueHandle, 1, zeroWaitTime)
+	if len(first) != 1 || string(first[0].Payload) != string(messages[0].Payload) {
+		t.Fatalf("Expected the first message sent\n")
+	}
+	blocked, _ := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, zeroWaitTime)
+	if len(blocked) != 0 {
+		t.Fatalf("Received a message while the group was in flight\n")
+	}
+
+	_, _ = awssqs.BatchMessageDelete(queueHandle, first)
+
+	rest, _ := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, zeroWaitTime)
+	if uint(len(rest)) != count-1 {
+		t.Fatalf("Received a different number of messages than expected (expected: %d, received: %d)\n", count-1, len(rest))
+	}
+	for ix, m := range rest {
+		if string(m.Payload) != string(messages[ix+1].Payload) {
+			t.Fatalf("Message %d received out of order\n", ix)
+		}
+	}
+}
+
+func TestInMemoryFifoMessageGroups(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryFifoQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryFifoQueueName)
+
+	messages := makeSmallMessages(4)
+	messages[0].MessageGroupId = "group-a"
+	messages[1].MessageGroupId = "group-a"
+	messages[2].MessageGroupId = "group-b"
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	received, _ := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, zeroWaitTime)
+	if len(received) != len(messages) {
+		t.Fatalf("Received a different number of messages than expected (expected: %d, received: %d)\n", len(messages), len(received))
+	}
+	expected := []string{"group-a", "group-a", "group-b", DefaultMessageGroupId}
+	for ix, m := range received {
+		if m.MessageGroupId != expected[ix] {
+			t.Fatalf("Unexpected message group. Expected %s, got %s\n", expected[ix], m.MessageGroupId)
+		}
+		if len(m.MessageDeduplicationId) == 0 {
+			t.Fatalf("Expected a deduplication id\n")
+		}
+		if ix != 0 && m.SequenceNumber <= received[ix-1].SequenceNumber {
+			t.Fatalf("Expected increasing sequence numbers\n")
+		}
+	}
+}
+
+func TestInMemoryFifoContentBasedDeduplication(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryFifoQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryFifoQueueName)
+	inMemoryQueueFor(awssqs, queueHandle).contentDedup = false
+
+	// the queue does not provide deduplication ids
+	ops, err := awssqs.BatchMessagePut(queueHandle, makeStandardMessages(1))
+	if err != ErrOneOrMoreOperationsUnsuccessful || ops[0] == true {
+		t.Fatalf("%t\n", err)
+	}
+
+	// explicit deduplication ids are honoured
+	messages := makeStandardMessages(2)
+	messages[0].MessageDeduplicationId = "duplicate"
+	messages[1].MessageDeduplicationId = "duplicate"
+	_, err = awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// and generated ones are derived from the original payload so work for oversize messages too
+	inMemoryBackend(awssqs).config.ContentBasedDeduplication = true
+	large := makeLargeMessage()
+	_, err = awssqs.BatchMessagePut(queueHandle, []Message{*large.ContentClone(), *large.ContentClone()})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	available, _ := awssqs.GetMessagesAvailable(inMemoryFifoQueueName)
+	if available != 2 {
+		t.Fatalf("Expected 2 messages available, found %d\n", available)
+	}
+}
+
+func TestInMemoryTypedAttributes(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	typed := []Attribute{
+		{Name: "count", Value: "42", DataType: "Number.int"},
+		{Name: "raw", BinaryValue: []byte{0, 1, 2, 255}, DataType: AttributeDataTypeBinary},
+		{Name: "zipped", BinaryValue: []byte{31, 139}, DataType: "Binary.gzip"},
+	}
+
+	messages := append(makeStandardMessages(1), makeLargeMessages(1)...)
+	for ix := range messages {
+		before := messages[ix].Size()
+		messages[ix].Attribs = append(messages[ix].Attribs, typed...)
+		if messages[ix].Size() <= before+uint(len(typed[1].BinaryValue)+len(typed[2].BinaryValue)) {
+			t.Fatalf("Binary attributes not included in the message size\n")
+		}
+	}
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, zeroWaitTime)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(received) != len(messages) {
+		t.Fatalf("Received a different number of messages than expected (expected: %d, received: %d)\n", len(messages), len(received))
+	}
+	for _, m := range received {
+		for _, expected := range typed {
+			found := false
+			for _, a := range m.Attribs {
+				if a.Name == expected.Name {
+					found = a.DataType == expected.DataType && a.Value == expected.Value &&
+						bytes.Equal(a.BinaryValue, expected.BinaryValue)
+				}
+			}
+			if found == false {
+				t.Fatalf("Attribute %s not received correctly\n", expected.Name)
+			}
+		}
+	}
+}
+
+func TestInMemoryBatchMessageGetCancel(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := awssqs.BatchMessageGetWithContext(ctx, queueHandle, 1, goodWaitTime)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("%t\n", err)
+	}
+	if time.Since(start) >= goodWaitTime {
+		t.Fatalf("Cancelled receive waited for the full wait time\n")
+	}
+}
+
+func TestInMemoryQueueHandleBadName(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	_, err := awssqs.QueueHandle(badQueueName)
+	if err != ErrBadQueueName {
+		t.Fatalf("%t\n", err)
+	}
+
+	_, err = awssqs.BatchMessageGet(badQueueHandle, 1, zeroWaitTime)
+	if err != ErrBadQueueHandle {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+//
+// BatchResult tests
+//
+
+func TestBatchMessageDeleteWithResultBadReceiptHandle(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	messages := makeStandardMessages(2)
+	messages[1].ReceiptHandle = badReceiptHandle
+
+	results, err := awssqs.BatchMessageDeleteWithResultWithContext(context.Background(), queueHandle, messages)
+	if err != ErrOneOrMoreOperationsUnsuccessful {
+		t.Fatalf("%t\n", err)
+	}
+	if len(results) != 2 || results.AllSuccessful() == true {
+		t.Fatalf("Delete operation reported success incorrectly\n")
+	}
+	r := results[1]
+	if r.Code != sqs.ErrCodeReceiptHandleIsInvalid || r.SenderFault == false || r.Retryable == true {
+		t.Fatalf("Unexpected failure details %+v\n", r)
+	}
+	if results.OpStatus()[1] == true {
+		t.Fatalf("Delete operation status reported success incorrectly\n")
+	}
+}
+
+func TestBatchMessagePutWithResultPayloadStoreFailure(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	inMemoryBackend(awssqs).store = failingPayloadStore{}
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	messages := append(makeStandardMessages(1), makeLargeMessages(1)...)
+	results, err := awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, messages)
+	if err != ErrOneOrMoreOperationsUnsuccessful {
+		t.Fatalf("%t\n", err)
+	}
+	if results[0].Success == false {
+		t.Fatalf("Put operation reported failed incorrectly\n")
+	}
+	r := results[1]
+	if r.Success == true || r.Code != BatchEntryCodePayloadStoreFailure || r.Retryable == false {
+		t.Fatalf("Unexpected failure details %+v\n", r)
+	}
+}
+
+//
+// helper methods
+//
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// a payload store that always fails
+type failingPayloadStore struct{}
+
+func (failingPayloadStore) Put(ctx context.Context, bucket string, key string, payload []byte) error {
+	return fmt.Errorf("payload store failure")
+}
+
+func (failingPayloadStore) Get(ctx context.Context, bucket string, key string) ([]byte, error) {
+	return nil, fmt.Errorf("payload store failure")
+}
+
+func (failingPayloadStore) Delete(ctx context.Context, bucket string, key string) error {
+	return fmt.Errorf("payload store failure")
+}
+
+func inMemoryBackend(awssqs AWS_SQS) *awsSqsImpl {
+	return awssqs.(*awsSqsImpl)
+}
+
+func inMemoryQueueFor(awssqs AWS_SQS, queue QueueHandle) *inMemoryQueue {
+	return inMemoryBackend(awssqs).svc.(*inMemorySqsService).queues[string(queue)]
+}
+
+func useTestClock(awssqs AWS_SQS) *testClock {
+	clock := &testClock{now: time.Now()}
+	svc := inMemoryBackend(awssqs).svc.(*inMemorySqsService)
+	svc.mu.Lock()
+	svc.now = func() time.Time { return clock.now }
+	svc.mu.Unlock()
+	return clock
+}
+
+//
+// end of file
+//
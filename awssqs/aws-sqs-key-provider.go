@@ -0,0 +1,93 @@
+package awssqs
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"strings"
+)
+
+// the size of the keys in a keyfile and of the data keys (AES-256)
+var dataKeySize = 32
+
+//
+// local keyfile key provider
+//
+
+type localKeyProvider struct {
+	current string            // the id of the key that wraps new data keys
+	keys    map[string][]byte // keyed by key id
+}
+
+// factory for the local keyfile key provider
+func newLocalKeyProvider(keyfile string) (*localKeyProvider, error) {
+
+	f, err := os.Open(keyfile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	provider := &localKeyProvider{keys: make(map[string][]byte)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") == true {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, ErrBadKeyfile
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != dataKeySize {
+			return nil, ErrBadKeyfile
+		}
+		if _, found := provider.keys[fields[0]]; found == true {
+			return nil, ErrBadKeyfile
+		}
+
+		if len(provider.current) == 0 {
+			provider.current = fields[0]
+		}
+		provider.keys[fields[0]] = key
+	}
+	if scanner.Err() != nil {
+		return nil, scanner.Err()
+	}
+
+	if len(provider.current) == 0 {
+		return nil, ErrBadKeyfile
+	}
+	return provider, nil
+}
+
+func (p *localKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, string, error) {
+
+	key := make([]byte, dataKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	wrappedKey, err := seal(p.keys[p.current], key, []byte(p.current))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return key, wrappedKey, p.current, nil
+}
+
+func (p *localKeyProvider) UnwrapDataKey(ctx context.Context, keyId string, wrappedKey []byte) ([]byte, error) {
+
+	key, found := p.keys[keyId]
+	if found == false {
+		return nil, ErrUnknownKey
+	}
+	return open(key, wrappedKey, []byte(keyId))
+}
+
+//
+// end of file
+//
@@ -0,0 +1,78 @@
+package awssqs
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// the logger used when none is configured
+var defaultLogger Logger = stdLogger{}
+
+//
+// standard logger, writes to the standard log using the same prefixes as always
+//
+
+type stdLogger struct{}
+
+func (stdLogger) Info(msg string, args ...any) {
+	log.Printf("INFO: %s%s", msg, formatLogArgs(args))
+}
+
+func (stdLogger) Warn(msg string, args ...any) {
+	log.Printf("WARNING: %s%s", msg, formatLogArgs(args))
+}
+
+func (stdLogger) Error(msg string, args ...any) {
+	log.Printf("ERROR: %s%s", msg, formatLogArgs(args))
+}
+
+// format the key/value pairs as " key=value key=value", an unpaired value is reported as such
+func formatLogArgs(args []any) string {
+
+	var sb strings.Builder
+	for ix := 0; ix < len(args); ix += 2 {
+		if ix+1 == len(args) {
+			fmt.Fprintf(&sb, " !BADKEY=%v", args[ix])
+			break
+		}
+		fmt.Fprintf(&sb, " %v=%v", args[ix], args[ix+1])
+	}
+	return sb.String()
+}
+
+//
+// silent logger, discards everything
+//
+
+type silentLogger struct{}
+
+func (silentLogger) Info(msg string, args ...any)  {}
+func (silentLogger) Warn(msg string, args ...any)  {}
+func (silentLogger) Error(msg string, args ...any) {}
+
+//
+// slog adapter, *slog.Logger already satisfies our interface
+//
+
+func newSlogLogger(logger *slog.Logger) Logger {
+
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// get the logger used by an SQS implementation, the default logger if it is not one of ours
+func loggerFor(sqs AWS_SQS) Logger {
+
+	if awsi, ok := sqs.(*awsSqsImpl); ok == true {
+		return awsi.log
+	}
+	return defaultLogger
+}
+
+//
+// end of file
+//
@@ -0,0 +1,83 @@
+package awssqs
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//
+// Logger behavior tests
+//
+
+func TestLoggerConfigured(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	logger := &recordingLogger{}
+	inMemoryBackend(awssqs).log = logger
+	inMemoryBackend(awssqs).store = failingPayloadStore{}
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, makeLargeMessages(1))
+	if err != ErrOneOrMoreOperationsUnsuccessful {
+		t.Fatalf("%t\n", err)
+	}
+	if logger.contains("WARN: failed converting oversize message") == false {
+		t.Fatalf("Expected a warning from the configured logger, got %v\n", logger.lines)
+	}
+
+	// the consumer uses the same logger
+	consumer, _ := NewConsumer(awssqs, ConsumerConfig{Queue: queueHandle, Handler: func(Message) error { return nil }})
+	if consumer.(*consumerImpl).log != logger {
+		t.Fatalf("Expected the consumer to use the configured logger\n")
+	}
+}
+
+func TestLoggerFormatArgs(t *testing.T) {
+
+	s := formatLogArgs([]any{"id", "1", "size", 10})
+	if s != " id=1 size=10" {
+		t.Fatalf("Unexpected formatted arguments [%s]\n", s)
+	}
+
+	s = formatLogArgs([]any{"id", "1", "orphan"})
+	if s != " id=1 !BADKEY=orphan" {
+		t.Fatalf("Unexpected formatted arguments [%s]\n", s)
+	}
+}
+
+//
+// helper methods
+//
+
+// a logger that records everything logged
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(level string, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+": "+msg+formatLogArgs(args))
+}
+
+func (l *recordingLogger) Info(msg string, args ...any)  { l.record("INFO", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...any)  { l.record("WARN", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...any) { l.record("ERROR", msg, args) }
+
+func (l *recordingLogger) contains(prefix string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, prefix) == true {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// end of file
+//
@@ -1,15 +1,14 @@
 package awssqs
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/google/uuid"
-	"log"
 	"strconv"
 	"strings"
-
-	"github.com/uvalib/uva-aws-s3-sdk/uva-s3"
 )
 
 // support for large messages (using S3)
@@ -20,32 +19,59 @@ var bucketKeyMarker = "-..s3Key..-"
 
 var s3BucketMapKeyValue = "s3BucketName"
 var s3KeyMapKeyValue = "s3Key"
+var s3ChecksumMapKeyValue = "s3PayloadMD5"
 var s3MarkerTag = "com.amazon.sqs.javamessaging.MessageS3Pointer"
 
-//
+// the newer (version 2 and later) Java and Python libraries use a different attribute name and marker tag
+var extendedPayloadSizeAttributeName = "ExtendedPayloadSize"
+var payloadS3PointerMarkerTag = "software.amazon.payloadoffloading.PayloadS3Pointer"
+
 // we need to be compatible with the Java library that provides oversize message support... this is an example of the
 // structure they write
 //
 // [ "com.amazon.sqs.javamessaging.MessageS3Pointer",
-//   { "s3BucketName":"virgo4-ingest-staging-messages",
-//     "s3Key":"9b9e4bc4-8bd8-4527-a25e-818f17dd5aab"
-//   }
-// ]
 //
+//	{ "s3BucketName":"virgo4-ingest-staging-messages",
+//	  "s3Key":"9b9e4bc4-8bd8-4527-a25e-818f17dd5aab"
+//	}
 //
+// ]
+//
+// the newer libraries write the same structure with the "software.amazon.payloadoffloading.PayloadS3Pointer" tag
 type S3MarkerPayload [2]interface{}
 
-var s3Svc uva_s3.UvaS3
+// our message factory based on a message from AWS. Oversize payloads are read from S3 and there is no
+// key provider so an encrypted message is incomplete (ErrNoKeyProvider), use MakeMessageFrom to use the
+// payload store and key provider of an SQS implementation
+func MakeMessage(awsMessage sqs.Message) (*Message, error) {
+	return makeMessage(context.Background(), awsMessage, defaultPayloadStore, nil, defaultLogger)
+}
 
-func init() {
-	// dont really like ignoring the error here...
-	s3Svc, _ = uva_s3.NewUvaS3(uva_s3.UvaS3Config{Logging: true})
+// MakeMessageWithContext our message factory based on a message from AWS, cancelling the context
+// abandons the read of any oversize payload
+func MakeMessageWithContext(ctx context.Context, awsMessage sqs.Message) (*Message, error) {
+	return makeMessage(ctx, awsMessage, defaultPayloadStore, nil, defaultLogger)
 }
 
-//
-// our message factory based on a message from AWS
-//
-func MakeMessage(awsMessage sqs.Message) (*Message, error) {
+// MakeMessageFrom our message factory based on a message from AWS, the message is made as the SQS
+// implementation makes the messages it receives (using its payload store and key provider)
+func MakeMessageFrom(from AWS_SQS, awsMessage sqs.Message) (*Message, error) {
+	return MakeMessageFromWithContext(context.Background(), from, awsMessage)
+}
+
+// MakeMessageFromWithContext as MakeMessageFrom, cancelling the context abandons the read of any oversize payload
+func MakeMessageFromWithContext(ctx context.Context, from AWS_SQS, awsMessage sqs.Message) (*Message, error) {
+
+	awsi, ok := from.(*awsSqsImpl)
+	if ok == false {
+		return makeMessage(ctx, awsMessage, defaultPayloadStore, nil, loggerFor(from))
+	}
+	return makeMessage(ctx, awsMessage, awsi.storeFor(""), awsi.config.KeyProvider, awsi.log)
+}
+
+// make a message using the supplied payload store for any oversize payload
+// and the supplied key provider for any encrypted payload
+func makeMessage(ctx context.Context, awsMessage sqs.Message, store PayloadStore, keys KeyProvider, logger Logger) (*Message, error) {
 
 	message := new(Message)
 	message.ReceiptHandle = ReceiptHandle(*awsMessage.ReceiptHandle)
@@ -61,40 +87,88 @@ func MakeMessage(awsMessage sqs.Message) (*Message, error) {
 	if ok == true {
 		message.FirstReceived, _ = strconv.ParseUint(*v, 10, 64)
 	}
+	v, ok = awsMessage.Attributes["ApproximateReceiveCount"]
+	if ok == true {
+		count, _ := strconv.ParseUint(*v, 10, 32)
+		message.ReceiveCount = uint(count)
+	}
+	v, ok = awsMessage.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]
+	if ok == true {
+		message.MessageGroupId = *v
+	}
+	v, ok = awsMessage.Attributes[sqs.MessageSystemAttributeNameMessageDeduplicationId]
+	if ok == true {
+		message.MessageDeduplicationId = *v
+	}
+	v, ok = awsMessage.Attributes[sqs.MessageSystemAttributeNameSequenceNumber]
+	if ok == true {
+		message.SequenceNumber = *v
+	}
+
+	// ensure we received what was sent
+	err := verifyReceivedChecksums(awsMessage)
+	if err != nil {
+		logger.Warn("message checksum mismatch", "id", aws.StringValue(awsMessage.MessageId), "error", err)
+		// return the incomplete message and the error
+		message.Incomplete = true
+		return message, err
+	}
 
 	// check to see if this is a special 'oversize' message which stores the payload in S3, if it is, do the necessary processing
-	s3size, found := message.GetAttribute(oversizeMessageAttributeName)
+	sizeAttribute, found := message.oversizeSizeAttribute()
 	if found == true {
+		s3size := sizeAttribute.Value
 
 		//log.Printf( "INFO: constructing oversize message" )
 
-		// remove the 'marker' attribute we use for indicating this is a special type of message,
-		// we won't need it again
-		message.deleteAttribute(oversizeMessageAttributeName)
+		// remove the 'marker' attribute we use for indicating this is a special type of message, we
+		// only need it again to send the message again (with the stored payload size)
+		message.deleteAttribute(sizeAttribute.Name)
+		message.transport = append(message.transport, sizeAttribute)
+		checksum, checksumFound := message.GetAttribute(oversizeChecksumAttributeName)
+		if checksumFound == true {
+			message.deleteAttribute(oversizeChecksumAttributeName)
+			message.transport = append(message.transport, Attribute{Name: oversizeChecksumAttributeName, Value: checksum})
+		}
 
 		// extract the payload key from the existing payload
-		bucket, key, err := message.decodeS3MarkerInformation(message.Payload)
+		bucket, key, pointerChecksum, err := message.decodeS3MarkerInformation(message.Payload, logger)
 		if err != nil {
 			// errors logged in decodeS3MarkerInformation function
 			// return the incomplete message and the error
 			message.Incomplete = true
 			return message, err
 		}
+		if len(pointerChecksum) != 0 {
+			checksum, checksumFound = pointerChecksum, true
+		}
+		message.checksum = checksum
 
 		// use this later
 		sz, err := strconv.Atoi(s3size)
 		if err != nil {
-			log.Printf("WARNING: size conversion error (%s)", err.Error())
+			logger.Warn("size conversion error", "error", err)
 			// return the incomplete message and the error
 			message.Incomplete = true
 			return message, err
 		}
 
-		// get the actual message contents from S3
-		o := uva_s3.NewUvaS3Object(bucket, key)
-		contents, err := s3Svc.GetToBuffer(o)
+		// a streamed payload is not read until it is opened
+		streamed, streamedFound := message.GetAttribute(streamedPayloadAttributeName)
+		if streamedFound == true {
+			message.deleteAttribute(streamedPayloadAttributeName)
+			message.transport = append(message.transport, Attribute{Name: streamedPayloadAttributeName, Value: streamed})
+			message.oversize = true
+			message.store = store
+			message.ReceiptHandle = message.makeEnhancedReceiptHandle(bucket, key, message.ReceiptHandle)
+			message.Payload = nil
+			return message, nil
+		}
+
+		// get the actual message contents from the payload store
+		contents, err := store.Get(ctx, bucket, key)
 		if err != nil {
-			log.Printf("WARNING: missing/unavailable message payload (%s)", err.Error())
+			logger.Warn("missing/unavailable message payload", "bucket", bucket, "key", key, "error", err)
 			// return the incomplete message and the error
 			message.Incomplete = true
 			return message, err
@@ -102,14 +176,23 @@ func MakeMessage(awsMessage sqs.Message) (*Message, error) {
 
 		// ensure the actual size of the S3 object we read matches the reported size
 		if len(contents) != sz {
-			log.Printf("WARNING: unexpected message payload size. Expected %d, actual %d", sz, len(contents))
+			logger.Warn("unexpected message payload size", "expected", sz, "actual", len(contents))
 			// return the incomplete message and the error
 			message.Incomplete = true
 			return message, ErrMismatchedContentsSize
 		}
 
-		// mark the message as oversize
+		// and that it is what was stored (payloads stored by the oldest versions have no checksum)
+		if checksumFound == true && md5Hex(contents) != checksum {
+			logger.Warn("message payload checksum mismatch", "bucket", bucket, "key", key)
+			// return the incomplete message and the error
+			message.Incomplete = true
+			return message, ErrChecksumMismatch
+		}
+
+		// mark the message as oversize and remember where the payload is
 		message.oversize = true
+		message.store = store
 
 		// construct the new receipt handle... we overload it with bucket and key information
 		// and save the 'enhanced' receipt handle
@@ -120,14 +203,72 @@ func MakeMessage(awsMessage sqs.Message) (*Message, error) {
 		message.Payload = contents
 	}
 
+	// reverse any encryption and compression of the payload
+	err = message.decodePayload(ctx, keys)
+	if err != nil {
+		logger.Warn("failed decoding message payload", "error", err)
+		// return the incomplete message and the error
+		message.Incomplete = true
+		return message, err
+	}
+
 	return message, nil
 }
 
+// reverse any encryption and then any compression of the payload, removing the attributes that describe
+// them. The attributes are kept aside because they also describe the stored payload of an oversize message
+func (m *Message) decodePayload(ctx context.Context, keys KeyProvider) error {
+
+	for _, name := range []string{encryptionKeyIdAttributeName, encryptedDataKeyAttributeName, compressionAttributeName} {
+		for _, a := range m.Attribs {
+			if a.Name == name {
+				m.transport = append(m.transport, a)
+				m.deleteAttribute(name)
+				break
+			}
+		}
+	}
+
+	for _, a := range m.transport {
+		if a.Name == encryptionKeyIdAttributeName {
+			var wrappedKey []byte
+			for _, k := range m.transport {
+				if k.Name == encryptedDataKeyAttributeName {
+					wrappedKey = k.BinaryValue
+				}
+			}
+			payload, err := decryptPayload(ctx, keys, a.Value, wrappedKey, m.Payload)
+			if err != nil {
+				return err
+			}
+			m.Payload = payload
+		}
+	}
+
+	for _, a := range m.transport {
+		if a.Name == compressionAttributeName {
+			payload, err := decompressPayload(PayloadCompression(a.Value), m.Payload)
+			if err != nil {
+				return err
+			}
+			m.Payload = payload
+		}
+	}
+
+	return nil
+}
+
 // make a set of our message attributes from AWS message metadata
 func makeAttributes(attribs map[string]*sqs.MessageAttributeValue) Attributes {
 	attributes := make([]Attribute, 0, len(attribs))
 	for k, v := range attribs {
-		attributes = append(attributes, Attribute{Name: k, Value: *v.StringValue})
+		a := Attribute{Name: k, DataType: aws.StringValue(v.DataType)}
+		if a.isBinary() == true {
+			a.BinaryValue = v.BinaryValue
+		} else {
+			a.Value = aws.StringValue(v.StringValue)
+		}
+		attributes = append(attributes, a)
 	}
 	a := Attributes(attributes)
 	return a
@@ -144,7 +285,7 @@ func (m *Message) Size() uint {
 	var padFactor = 3 // a guess at the padding for each string in the attribute set
 	sz := uint(len(m.Payload))
 	for _, a := range m.Attribs {
-		sz += uint(len(a.Name) + len(a.Value) + (2 * padFactor))
+		sz += uint(len(a.Name) + len(a.dataType()) + len(a.Value) + len(a.BinaryValue) + (2 * padFactor))
 	}
 	//log.Printf( "INFO: reporting size %d", sz )
 	return sz
@@ -158,6 +299,29 @@ func (m *Message) IsOversize() bool {
 // if this is an oversize  message, delete the bucket contents
 func (m *Message) DeleteOversizeMessage() error {
 
+	// use the store the payload was read from or written to if we know it
+	store := m.store
+	if store == nil {
+		store = defaultPayloadStore
+	}
+	return m.deleteOversizeMessage(context.Background(), store)
+}
+
+func (m *Message) ConvertToOversizeMessage(bucket string) error {
+	return m.convertToOversizeMessage(context.Background(), defaultPayloadStore, bucket, uuid.New().String(), PointerFormatMessageS3Pointer)
+}
+
+// ConvertToOversizeMessageWithFormat convert to an oversize message using the specified payload pointer format
+func (m *Message) ConvertToOversizeMessageWithFormat(bucket string, format PointerFormat) error {
+	if validPointerFormat(format) == false {
+		return ErrBadPointerFormat
+	}
+	return m.convertToOversizeMessage(context.Background(), defaultPayloadStore, bucket, uuid.New().String(), format)
+}
+
+// delete the bucket contents of an oversize message using the supplied payload store
+func (m *Message) deleteOversizeMessage(ctx context.Context, store PayloadStore) error {
+
 	// if this is not an oversize message, then ignore
 	if m.oversize == false {
 		return nil
@@ -168,14 +332,15 @@ func (m *Message) DeleteOversizeMessage() error {
 	// an oversize 'large' messages encodes the bucket attributes in the receipt handle
 	bucket, key := m.getBucketAttributes(m.ReceiptHandle)
 	if bucket != "" && key != "" {
-		o := uva_s3.NewUvaS3Object(bucket, key)
-		return s3Svc.DeleteObject(o)
+		return store.Delete(ctx, bucket, key)
 	}
 
 	return ErrBadReceiptHandle
 }
 
-func (m *Message) ConvertToOversizeMessage(bucket string) error {
+// convert to an oversize message, the payload is stored at the supplied key using the supplied payload store
+// and the message refers to it using the supplied pointer format
+func (m *Message) convertToOversizeMessage(ctx context.Context, store PayloadStore, bucket string, key string, format PointerFormat) error {
 
 	// if this is already marked as an oversize message, then ignore
 	if m.oversize == true {
@@ -184,30 +349,40 @@ func (m *Message) ConvertToOversizeMessage(bucket string) error {
 
 	//log.Printf( "INFO: converting oversize message" )
 
-	// add the contents to S3
-	key := uuid.New().String()
-	o := uva_s3.NewUvaS3Object(bucket, key)
-	err := s3Svc.PutFromBuffer(o, m.Payload)
+	// add the contents to the payload store
+	err := store.Put(ctx, bucket, key, m.Payload)
 	if err != nil {
 		return err
 	}
 
+	m.markOversize(store, bucket, key, len(m.Payload), md5Hex(m.Payload), format)
+	return nil
+}
+
+// replace the payload with the marker referring to the payload stored at the bucket and key, the
+// attributes describe the stored payload using the supplied pointer format
+func (m *Message) markOversize(store PayloadStore, bucket string, key string, size int, checksum string, format PointerFormat) {
+
 	// create the replacement contents for the message
-	contents := m.encodeS3MarkerInformation(bucket, key)
+	contents := m.encodeS3MarkerInformation(bucket, key, checksum, format)
 
 	// create the enhanced receipt handle
 	m.ReceiptHandle = m.makeEnhancedReceiptHandle(bucket, key, m.ReceiptHandle)
 
 	// add the special message attribute we use to identify an oversize message
-	m.addAttribute(oversizeMessageAttributeName, strconv.Itoa(len(m.Payload)))
+	if format == PointerFormatPayloadS3Pointer {
+		m.setNumberAttribute(extendedPayloadSizeAttributeName, strconv.Itoa(size))
+	} else {
+		m.addAttribute(oversizeMessageAttributeName, strconv.Itoa(size))
+	}
 
 	// replace the contents of the original message with the new contents
 	m.Payload = contents
 
-	// mark as oversize
+	// mark as oversize and remember where the payload is
 	m.oversize = true
-
-	return nil
+	m.store = store
+	m.checksum = checksum
 }
 
 // because the receipt handle is overloaded, we use a helper method to access it
@@ -232,12 +407,49 @@ func (m *Message) GetAttribute(attribute string) (string, bool) {
 	return "", false
 }
 
-// clone the content but none of the internal state
+// make a copy of a received message for sending again. The copy of an oversize message refers to
+// the same stored payload rather than having a copy of it
+func (m *Message) redriveClone() Message {
+
+	clone := Message{
+		Attribs:                append(Attributes{}, m.Attribs...),
+		Payload:                m.Payload,
+		MessageGroupId:         m.MessageGroupId,
+		MessageDeduplicationId: uuid.New().String(), // this is a new message so cannot be a duplicate
+	}
+
+	if m.oversize == true {
+		bucket, key := m.getBucketAttributes(m.ReceiptHandle)
+		format := PointerFormatMessageS3Pointer
+		for _, a := range m.transport {
+			if a.Name == extendedPayloadSizeAttributeName {
+				format = PointerFormatPayloadS3Pointer
+			}
+		}
+		if format == PointerFormatMessageS3Pointer {
+			clone.addAttribute(oversizeMessageAttributeName, strconv.Itoa(len(m.Payload)))
+		}
+		for _, a := range m.transport {
+			clone.deleteAttribute(a.Name)
+			clone.Attribs = append(clone.Attribs, a)
+		}
+		clone.Payload = m.encodeS3MarkerInformation(bucket, key, m.checksum, format)
+		clone.ReceiptHandle = m.makeEnhancedReceiptHandle(bucket, key, "")
+		clone.oversize = true
+		clone.store = m.store
+		clone.checksum = m.checksum
+	}
+
+	return clone
+}
+
+// clone the content (and message group) but none of the internal state
 func (m *Message) ContentClone() *Message {
 
 	newMessage := new(Message)
 	newMessage.Attribs = m.Attribs
 	newMessage.Payload = m.Payload
+	newMessage.MessageGroupId = m.MessageGroupId
 	return newMessage
 }
 
@@ -245,37 +457,70 @@ func (m *Message) ContentClone() *Message {
 // implementation methods
 //
 
-// decode the S3 marker information from the supplied payload
-func (m *Message) decodeS3MarkerInformation(payload []byte) (string, string, error) {
+// decode the S3 marker information from the supplied payload, the payload checksum is empty if the
+// marker does not include it
+func (m *Message) decodeS3MarkerInformation(payload []byte, logger Logger) (string, string, string, error) {
 
 	s3MarkerPayload := S3MarkerPayload{}
 	err := json.Unmarshal([]byte(payload), &s3MarkerPayload)
 	if err != nil {
-		log.Printf("ERROR: json unmarshal: %s", err)
-		return "", "", err
+		logger.Error("json unmarshal", "error", err)
+		return "", "", "", err
 	}
 
 	s3, ok := s3MarkerPayload[1].(map[string]interface{})
 	if ok == false {
-		log.Printf("ERROR: type assertion error in decodeS3MarkerInformation")
-		return "", "", fmt.Errorf("type assertion error")
+		logger.Error("type assertion error in decodeS3MarkerInformation")
+		return "", "", "", fmt.Errorf("type assertion error")
 	}
 
 	// wildly optimistic that these assertions will not fail
 	bucket, _ := s3[s3BucketMapKeyValue].(string)
 	key, _ := s3[s3KeyMapKeyValue].(string)
-	return bucket, key, nil
+	checksum, _ := s3[s3ChecksumMapKeyValue].(string)
+	return bucket, key, checksum, nil
+}
+
+// is the pointer format one we support
+func validPointerFormat(format PointerFormat) bool {
+	return format == "" || format == PointerFormatMessageS3Pointer || format == PointerFormatPayloadS3Pointer
 }
 
-// encode the S3 marker information based on the supplied bucket information
-func (m *Message) encodeS3MarkerInformation(bucket string, key string) []byte {
+// get the attribute identifying an oversize message (and giving the stored payload size) in either format
+func (m *Message) oversizeSizeAttribute() (Attribute, bool) {
 
-	return []byte(fmt.Sprintf("[\"%s\",{\"%s\":\"%s\",\"%s\":\"%s\"}]",
-		s3MarkerTag,
+	for _, a := range m.Attribs {
+		if a.Name == oversizeMessageAttributeName || a.Name == extendedPayloadSizeAttributeName {
+			return a, true
+		}
+	}
+	return Attribute{}, false
+}
+
+// encode the S3 marker information based on the supplied bucket information and pointer format, the
+// payload checksum is included if there is one
+func (m *Message) encodeS3MarkerInformation(bucket string, key string, checksum string, format PointerFormat) []byte {
+
+	tag := s3MarkerTag
+	if format == PointerFormatPayloadS3Pointer {
+		tag = payloadS3PointerMarkerTag
+	}
+	if len(checksum) == 0 {
+		return []byte(fmt.Sprintf("[\"%s\",{\"%s\":\"%s\",\"%s\":\"%s\"}]",
+			tag,
+			s3BucketMapKeyValue,
+			bucket,
+			s3KeyMapKeyValue,
+			key))
+	}
+	return []byte(fmt.Sprintf("[\"%s\",{\"%s\":\"%s\",\"%s\":\"%s\",\"%s\":\"%s\"}]",
+		tag,
 		s3BucketMapKeyValue,
 		bucket,
 		s3KeyMapKeyValue,
-		key))
+		key,
+		s3ChecksumMapKeyValue,
+		checksum))
 }
 
 // extract the bucket attributes from the enhanced receipt handle according to the standard format
@@ -301,19 +546,15 @@ func (m *Message) splitEnhancedReceiptHandle(receiptHandle ReceiptHandle) (strin
 	keyTokens := strings.Split(string(receiptHandle), bucketKeyMarker)
 	bucket, key, receipt := "", "", ReceiptHandle("")
 
-	// do we have what we need to extract the bucket name
+	// do we have what we need to extract the bucket name (the caller reports a missing value)
 	if len(bucketTokens) == 3 {
 		bucket = bucketTokens[1]
-	} else {
-		log.Printf("WARNING: cannot find bucket value in receipt handle")
 	}
 
 	// do we have what we need to extract the key name and original receipt handle
 	if len(keyTokens) == 3 {
 		key = keyTokens[1]
 		receipt = ReceiptHandle(keyTokens[2])
-	} else {
-		log.Printf("WARNING: cannot find key value in receipt handle")
 	}
 
 	return bucket, key, receipt
@@ -342,6 +583,20 @@ func (m *Message) deleteAttribute(attribute string) bool {
 	return false
 }
 
+// the attribute data type, attributes without one are strings
+func (a *Attribute) dataType() string {
+	if len(a.DataType) == 0 {
+		return AttributeDataTypeString
+	}
+	return a.DataType
+}
+
+// is this a binary attribute (including any custom binary type)
+func (a *Attribute) isBinary() bool {
+	dt := a.dataType()
+	return dt == AttributeDataTypeBinary || strings.HasPrefix(dt, AttributeDataTypeBinary+".")
+}
+
 //
 // end of file
 //
@@ -0,0 +1,116 @@
+package awssqs
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// log a warning if any SQS request takes longer than this
+var warnIfRequestTakesLonger = 250 * time.Millisecond
+
+//
+// slow request observer, the default observer. Sometimes it is interesting to know if our SQS queries are slow
+//
+
+type slowRequestObserver struct {
+	log Logger
+}
+
+func newSlowRequestObserver(logger Logger) Observer {
+	return &slowRequestObserver{log: logger}
+}
+
+func (o *slowRequestObserver) Observe(event ObserverEvent) {
+
+	// payload transfers and block splits are not SQS requests
+	switch event.Operation {
+	case OperationReceive, OperationSend, OperationDelete, OperationVisibilityChange:
+	default:
+		return
+	}
+
+	// a long poll receive that yields no messages is expected to be slow
+	if event.Operation == OperationReceive && event.Messages == 0 {
+		return
+	}
+
+	if event.Latency >= warnIfRequestTakesLonger {
+		o.log.Info("slow request", "request", string(event.Operation), "elapsed_ms", event.Latency.Milliseconds())
+	}
+}
+
+//
+// observed payload store, reports the payload transfers made on behalf of a queue
+//
+
+type observedPayloadStore struct {
+	store    PayloadStore
+	observer Observer
+	queue    QueueHandle
+}
+
+// get the payload store used for the oversize messages of the specified queue, its operations are
+// observed and retried
+func (awsi *awsSqsImpl) storeFor(queue QueueHandle) PayloadStore {
+	observed := &observedPayloadStore{store: awsi.store, observer: awsi.observer, queue: queue}
+	return &retryingPayloadStore{store: observed, policy: awsi.retry}
+}
+
+func (s *observedPayloadStore) Put(ctx context.Context, bucket string, key string, payload []byte) error {
+	start := time.Now()
+	err := s.store.Put(ctx, bucket, key, payload)
+	s.observe(OperationPayloadPut, start, uint(len(payload)), err)
+	return err
+}
+
+func (s *observedPayloadStore) Get(ctx context.Context, bucket string, key string) ([]byte, error) {
+	start := time.Now()
+	payload, err := s.store.Get(ctx, bucket, key)
+	s.observe(OperationPayloadGet, start, uint(len(payload)), err)
+	return payload, err
+}
+
+func (s *observedPayloadStore) Delete(ctx context.Context, bucket string, key string) error {
+	start := time.Now()
+	err := s.store.Delete(ctx, bucket, key)
+	s.observe(OperationPayloadDelete, start, 0, err)
+	return err
+}
+
+func (s *observedPayloadStore) PutStream(ctx context.Context, bucket string, key string, payload io.Reader) error {
+	streamer, ok := s.store.(PayloadStreamer)
+	if ok == false {
+		return ErrPayloadStreamNotSupported
+	}
+	start := time.Now()
+	counted := &countingReader{reader: payload}
+	err := streamer.PutStream(ctx, bucket, key, counted)
+	s.observe(OperationPayloadPut, start, counted.count, err)
+	return err
+}
+
+// only opening the stream is observed, the payload is read later
+func (s *observedPayloadStore) GetStream(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	streamer, ok := s.store.(PayloadStreamer)
+	if ok == false {
+		return nil, ErrPayloadStreamNotSupported
+	}
+	start := time.Now()
+	payload, err := streamer.GetStream(ctx, bucket, key)
+	s.observe(OperationPayloadGet, start, 0, err)
+	return payload, err
+}
+
+func (s *observedPayloadStore) observe(operation ObservedOperation, start time.Time, bytes uint, err error) {
+
+	event := ObserverEvent{Operation: operation, Queue: s.queue, Latency: time.Since(start), Messages: 1, Bytes: bytes, Err: err}
+	if err != nil {
+		event.Failures = 1
+	}
+	s.observer.Observe(event)
+}
+
+//
+// end of file
+//
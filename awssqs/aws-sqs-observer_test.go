@@ -0,0 +1,96 @@
+package awssqs
+
+import (
+	"sync"
+	"testing"
+)
+
+//
+// Observer behavior tests
+//
+
+func TestObserverEvents(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	observer := &recordingObserver{}
+	inMemoryBackend(awssqs).observer = observer
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, append(makeStandardMessages(1), makeLargeMessages(1)...))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	messages, _ := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, zeroWaitTime)
+	_, err = awssqs.BatchMessageDelete(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	expected := []ObservedOperation{OperationPayloadPut, OperationSend, OperationPayloadGet, OperationReceive,
+		OperationDelete, OperationPayloadDelete}
+	events := observer.events
+	if len(events) != len(expected) {
+		t.Fatalf("Unexpected events %+v\n", events)
+	}
+	for ix, e := range events {
+		if e.Operation != expected[ix] || e.Queue != queueHandle || e.Failures != 0 || e.Err != nil {
+			t.Fatalf("Unexpected event %+v\n", e)
+		}
+	}
+	if events[1].Messages != 2 || events[3].Messages != 2 || events[3].Bytes != messages[0].Size()+messages[1].Size() {
+		t.Fatalf("Unexpected message counts %+v\n", events)
+	}
+}
+
+func TestObserverBlockSplit(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	observer := &recordingObserver{}
+	inMemoryBackend(awssqs).observer = observer
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// two messages that each fit but together do not
+	messages := makeStandardMessages(2)
+	for ix := range messages {
+		messages[ix].Payload = make([]byte, MAX_SQS_BLOCK_SIZE/2+1)
+	}
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if observer.count(OperationBlockSplit) != 1 || observer.count(OperationSend) != 2 {
+		t.Fatalf("Unexpected events %+v\n", observer.events)
+	}
+}
+
+//
+// helper methods
+//
+
+// an observer that records every event
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []ObserverEvent
+}
+
+func (o *recordingObserver) Observe(event ObserverEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+}
+
+func (o *recordingObserver) count(operation ObservedOperation) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	count := 0
+	for _, e := range o.events {
+		if e.Operation == operation {
+			count++
+		}
+	}
+	return count
+}
+
+//
+// end of file
+//
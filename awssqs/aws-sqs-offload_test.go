@@ -0,0 +1,153 @@
+package awssqs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+//
+// payload offload behavior tests
+//
+
+func TestOffloadThreshold(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	store := newMemoryPayloadStore()
+	inMemoryBackend(awssqs).store = store
+	inMemoryBackend(awssqs).config.OversizeThreshold = 1024
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// only the message over the threshold is stored
+	messages := []Message{{Payload: randomPayload(512)}, {Payload: randomPayload(2048)}}
+	expected := [][]byte{messages[0].Payload, messages[1].Payload}
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(store.payloads) != 1 {
+		t.Fatalf("Expected one stored payload, got %d\n", len(store.payloads))
+	}
+
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(received) != 2 || received[0].IsOversize() == true || received[1].IsOversize() == false {
+		t.Fatalf("Expected only the message over the threshold to be oversize\n")
+	}
+	for ix, m := range received {
+		if bytes.Equal(m.Payload, expected[ix]) == false {
+			t.Fatalf("Unexpected message payload\n")
+		}
+	}
+
+	_, err = NewAwsSqs(AwsSqsConfig{MessageBucketName: inMemoryMessageBucketName, OversizeThreshold: MAX_SQS_MESSAGE_SIZE + 1})
+	if err != ErrBadOversizeThreshold {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+func TestOffloadAlways(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	store := newMemoryPayloadStore()
+	inMemoryBackend(awssqs).store = store
+	inMemoryBackend(awssqs).config.AlwaysOffload = true
+	inMemoryBackend(awssqs).config.Compression = CompressionGzip
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	messages := append(makeSmallMessages(2), Message{Payload: bytes.Repeat([]byte("compressible "), 100000)})
+	expected := [][]byte{messages[0].Payload, messages[1].Payload, messages[2].Payload}
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(store.payloads) != 3 {
+		t.Fatalf("Expected every payload to be stored, got %d\n", len(store.payloads))
+	}
+
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(received) != 3 {
+		t.Fatalf("Expected 3 messages, got %d\n", len(received))
+	}
+	for ix, m := range received {
+		if m.IsOversize() == false || bytes.Equal(m.Payload, expected[ix]) == false {
+			t.Fatalf("Expected an oversize message with the original payload\n")
+		}
+		if _, found := m.GetAttribute(compressionAttributeName); found == true {
+			t.Fatalf("Expected an uncompressed payload\n")
+		}
+	}
+}
+
+func TestOffloadKeyPrefix(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+	clock.advance(time.Date(2024, time.March, 7, 12, 0, 0, 0, time.UTC).Sub(clock.now))
+	store := newMemoryPayloadStore()
+	inMemoryBackend(awssqs).store = store
+	inMemoryBackend(awssqs).config.PayloadKeyPrefix = "{queue}/{year}/{month}/{day}/{date}-"
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeLargeMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	prefix := inMemoryQueueName + "/2024/03/07/2024-03-07-"
+	for k := range store.payloads {
+		if k.bucket != inMemoryMessageBucketName || strings.HasPrefix(k.key, prefix) == false || len(k.key) == len(prefix) {
+			t.Fatalf("Unexpected payload key %s/%s\n", k.bucket, k.key)
+		}
+	}
+
+	// the stored payload is found using the prefixed key
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 message, got %d\n", len(received))
+	}
+	verifyMessages(t, received)
+}
+
+func TestOffloadTooManyAttributes(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// SQS allows 10 attributes, storing the payload adds one more
+	messages := append(makeStandardMessages(1), makeLargeMessages(1)...)
+	for ix := range messages {
+		for len(messages[ix].Attribs) < int(MAX_SQS_ATTRIBUTE_COUNT) {
+			messages[ix].addAttribute(fmt.Sprintf("extra%d", len(messages[ix].Attribs)), "value")
+		}
+	}
+	results, err := awssqs.BatchMessagePutWithResult(queueHandle, messages)
+	if err != ErrOneOrMoreOperationsUnsuccessful {
+		t.Fatalf("%t\n", err)
+	}
+	if results[0].Success == false || results[1].Code != BatchEntryCodeTooManyAttributes || results[1].Retryable == true {
+		t.Fatalf("Expected only the oversize message to have too many attributes\n")
+	}
+
+	// the message is left as it was and its payload is not kept
+	if messages[1].IsOversize() == true || len(messages[1].Attribs) != int(MAX_SQS_ATTRIBUTE_COUNT) {
+		t.Fatalf("Expected the unsent message to be restored\n")
+	}
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	if store.count() != 0 {
+		t.Fatalf("Expected no oversize payloads, found %d\n", store.count())
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,96 @@
+package awssqs
+
+import (
+	"context"
+	"testing"
+)
+
+//
+// parallel receive behavior tests
+//
+
+func TestParallelReceive(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	for block := 0; block < 4; block++ {
+		_, err := awssqs.BatchMessagePut(queueHandle, makeSmallMessages(MAX_SQS_BLOCK_COUNT))
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+	}
+
+	messages, err := awssqs.BatchMessageGetParallelWithContext(context.Background(), queueHandle, 35, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(messages) != 35 {
+		t.Fatalf("Expected 35 messages, got %d\n", len(messages))
+	}
+	verifyMessages(t, messages)
+
+	receipts := make(map[ReceiptHandle]bool)
+	for _, m := range messages {
+		receipts[m.ReceiptHandle] = true
+	}
+	if len(receipts) != len(messages) {
+		t.Fatalf("Received the same message more than once\n")
+	}
+
+	messages, err = awssqs.BatchMessageGetParallelWithContext(context.Background(), queueHandle, 35, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(messages) != 5 {
+		t.Fatalf("Expected the remaining 5 messages, got %d\n", len(messages))
+	}
+
+	_, err = awssqs.BatchMessageGetParallelWithContext(context.Background(), queueHandle, MAX_SQS_PARALLEL_BLOCK_COUNT+1, 0)
+	if err != ErrParallelBlockCountTooLarge {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+func TestParallelReceiveOversize(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	sent := makeLargeMessages(12)
+	for block := 0; block < 12; block += 6 {
+		_, err := awssqs.BatchMessagePut(queueHandle, sent[block:block+6])
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+	}
+
+	// one of the messages is incomplete because its payload has gone
+	err := sent[3].DeleteOversizeMessage()
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	messages, err := awssqs.BatchMessageGetParallelWithContext(context.Background(), queueHandle, 20, 0)
+	if err != ErrPayloadNotFound {
+		t.Fatalf("%t\n", err)
+	}
+	if len(messages) != 12 {
+		t.Fatalf("Expected 12 messages, got %d\n", len(messages))
+	}
+
+	complete := make([]Message, 0)
+	for _, m := range messages {
+		if m.Incomplete == false {
+			complete = append(complete, m)
+		}
+	}
+	if len(complete) != 11 {
+		t.Fatalf("Expected one incomplete message, got %d\n", 12-len(complete))
+	}
+	verifyMessages(t, complete)
+}
+
+//
+// end of file
+//
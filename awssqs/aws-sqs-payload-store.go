@@ -0,0 +1,493 @@
+package awssqs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// the store used for oversize payloads when none is configured
+var defaultPayloadStore = &s3PayloadStore{}
+
+// the filesystem store writes payloads in this directory beneath the root directory before moving them into
+// place, bucket names cannot begin with a dot so it is never a bucket
+var filesystemTempDir = ".partial"
+
+//
+// S3 payload store, the default
+//
+
+type s3PayloadStore struct {
+	once     sync.Once
+	svc      *s3.S3
+	uploader *s3manager.Uploader
+	err      error
+}
+
+// factory for the S3 payload store
+func newS3PayloadStore() (PayloadStore, error) {
+
+	store := &s3PayloadStore{}
+	if err := store.init(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *s3PayloadStore) Put(ctx context.Context, bucket string, key string, payload []byte) error {
+
+	if err := store.init(); err != nil {
+		return err
+	}
+
+	_, err := store.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(payload),
+	})
+	return s3Error(ctx, err)
+}
+
+func (store *s3PayloadStore) Get(ctx context.Context, bucket string, key string) ([]byte, error) {
+
+	if err := store.init(); err != nil {
+		return nil, err
+	}
+
+	result, err := store.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3Error(ctx, err)
+	}
+	defer result.Body.Close()
+
+	payload, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, s3Error(ctx, err)
+	}
+	return payload, nil
+}
+
+func (store *s3PayloadStore) Delete(ctx context.Context, bucket string, key string) error {
+
+	if err := store.init(); err != nil {
+		return err
+	}
+
+	_, err := store.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	err = s3Error(ctx, err)
+	if err == ErrPayloadNotFound {
+		return nil
+	}
+	return err
+}
+
+func (store *s3PayloadStore) List(ctx context.Context, bucket string, prefix string) ([]StoredPayload, error) {
+
+	if err := store.init(); err != nil {
+		return nil, err
+	}
+
+	payloads := make([]StoredPayload, 0)
+	err := store.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			payloads = append(payloads, StoredPayload{
+				Key:          aws.StringValue(o.Key),
+				Size:         uint(aws.Int64Value(o.Size)),
+				LastModified: aws.TimeValue(o.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, s3Error(ctx, err)
+	}
+	return payloads, nil
+}
+
+func (store *s3PayloadStore) PutStream(ctx context.Context, bucket string, key string, payload io.Reader) error {
+
+	if err := store.init(); err != nil {
+		return err
+	}
+
+	// the uploader reads the payload a part at a time
+	_, err := store.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   payload,
+	})
+	return s3Error(ctx, err)
+}
+
+func (store *s3PayloadStore) GetStream(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+
+	if err := store.init(); err != nil {
+		return nil, err
+	}
+
+	result, err := store.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s3Error(ctx, err)
+	}
+	return result.Body, nil
+}
+
+// the S3 service is created on first use and any error creating it is reported on every use
+func (store *s3PayloadStore) init() error {
+
+	store.once.Do(func() {
+		sess, err := session.NewSession()
+		if err != nil {
+			store.err = err
+			return
+		}
+		store.svc = s3.New(sess)
+		store.uploader = s3manager.NewUploader(sess)
+	})
+	return store.err
+}
+
+// map S3 errors to our own, a cancelled context is reported as such
+func s3Error(ctx context.Context, err error) error {
+
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey:
+			return ErrPayloadNotFound
+		}
+	}
+	return err
+}
+
+//
+// local filesystem payload store, each bucket is a directory beneath the root directory
+//
+
+type filesystemPayloadStore struct {
+	root string
+}
+
+// factory for the filesystem payload store
+func newFilesystemPayloadStore(root string) (PayloadStore, error) {
+
+	if len(root) == 0 {
+		return nil, ErrMissingConfiguration
+	}
+	if err := os.MkdirAll(filepath.Join(root, filesystemTempDir), 0755); err != nil {
+		return nil, err
+	}
+	return &filesystemPayloadStore{root: root}, nil
+}
+
+func (store *filesystemPayloadStore) Put(ctx context.Context, bucket string, key string, payload []byte) error {
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	name, err := store.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	return store.write(name, bytes.NewReader(payload))
+}
+
+func (store *filesystemPayloadStore) Get(ctx context.Context, bucket string, key string) ([]byte, error) {
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	name, err := store.filename(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, ErrPayloadNotFound
+	}
+	return payload, err
+}
+
+func (store *filesystemPayloadStore) Delete(ctx context.Context, bucket string, key string) error {
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	name, err := store.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (store *filesystemPayloadStore) PutStream(ctx context.Context, bucket string, key string, payload io.Reader) error {
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	name, err := store.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	return store.write(name, payload)
+}
+
+func (store *filesystemPayloadStore) GetStream(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	name, err := store.filename(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, ErrPayloadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (store *filesystemPayloadStore) List(ctx context.Context, bucket string, prefix string) ([]StoredPayload, error) {
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if store.validBucket(bucket) == false {
+		return nil, ErrBadPayloadLocation
+	}
+	dir := filepath.Join(store.root, bucket)
+
+	payloads := make([]StoredPayload, 0)
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// partially written payloads are elsewhere
+		if entry.IsDir() == true {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) == false {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		payloads = append(payloads, StoredPayload{Key: key, Size: uint(info.Size()), LastModified: info.ModTime()})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return payloads, nil
+	}
+	return payloads, err
+}
+
+// make the filename for the bucket and key, ensuring it stays beneath the root directory
+func (store *filesystemPayloadStore) filename(bucket string, key string) (string, error) {
+
+	if len(key) == 0 || store.validBucket(bucket) == false || filepath.IsLocal(key) == false {
+		return "", ErrBadPayloadLocation
+	}
+	return filepath.Join(store.root, bucket, key), nil
+}
+
+// a bucket is a directory beneath the root directory, other than the one holding partially written payloads
+func (store *filesystemPayloadStore) validBucket(bucket string) bool {
+	return len(bucket) != 0 && filepath.IsLocal(bucket) == true && filepath.Clean(bucket) != filesystemTempDir
+}
+
+// write to a temporary file and rename so readers never see a partial payload
+func (store *filesystemPayloadStore) write(name string, payload io.Reader) error {
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Join(store.root, filesystemTempDir), "payload-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, payload)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+//
+// in-memory payload store
+//
+
+type memoryPayloadStore struct {
+	mu       sync.Mutex
+	payloads map[memoryPayloadKey][]byte
+	modified map[memoryPayloadKey]time.Time // when each payload was stored
+	now      func() time.Time               // replaceable for testing
+}
+
+// the location of a payload in the in-memory store
+type memoryPayloadKey struct {
+	bucket string
+	key    string
+}
+
+// factory for the in-memory payload store
+func newMemoryPayloadStore() *memoryPayloadStore {
+	return &memoryPayloadStore{payloads: make(map[memoryPayloadKey][]byte), modified: make(map[memoryPayloadKey]time.Time), now: time.Now}
+}
+
+func (store *memoryPayloadStore) Put(ctx context.Context, bucket string, key string, payload []byte) error {
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.payloads[memoryPayloadKey{bucket, key}] = append([]byte(nil), payload...)
+	store.modified[memoryPayloadKey{bucket, key}] = store.now()
+	return nil
+}
+
+func (store *memoryPayloadStore) Get(ctx context.Context, bucket string, key string) ([]byte, error) {
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	payload, found := store.payloads[memoryPayloadKey{bucket, key}]
+	if found == false {
+		return nil, ErrPayloadNotFound
+	}
+	return append([]byte(nil), payload...), nil
+}
+
+func (store *memoryPayloadStore) Delete(ctx context.Context, bucket string, key string) error {
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.payloads, memoryPayloadKey{bucket, key})
+	delete(store.modified, memoryPayloadKey{bucket, key})
+	return nil
+}
+
+func (store *memoryPayloadStore) PutStream(ctx context.Context, bucket string, key string, payload io.Reader) error {
+
+	// an in-memory store holds the payload in memory anyway
+	buf, err := io.ReadAll(payload)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, bucket, key, buf)
+}
+
+func (store *memoryPayloadStore) GetStream(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+
+	payload, err := store.Get(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(payload)), nil
+}
+
+func (store *memoryPayloadStore) List(ctx context.Context, bucket string, prefix string) ([]StoredPayload, error) {
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	payloads := make([]StoredPayload, 0)
+	for k, payload := range store.payloads {
+		if k.bucket == bucket && strings.HasPrefix(k.key, prefix) == true {
+			payloads = append(payloads, StoredPayload{Key: k.key, Size: uint(len(payload)), LastModified: store.modified[k]})
+		}
+	}
+	sort.Slice(payloads, func(i, j int) bool { return payloads[i].Key < payloads[j].Key })
+	return payloads, nil
+}
+
+// the number of payloads currently stored
+func (store *memoryPayloadStore) count() int {
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return len(store.payloads)
+}
+
+//
+// end of file
+//
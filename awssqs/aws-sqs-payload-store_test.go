@@ -0,0 +1,214 @@
+package awssqs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var payloadBucketName = "virgo4-test-messages"
+var payloadKeyName = "9b9e4bc4-8bd8-4527-a25e-818f17dd5aab"
+
+//
+// PayloadStore behavior tests
+//
+
+func TestMemoryPayloadStore(t *testing.T) {
+	verifyPayloadStore(t, NewMemoryPayloadStore())
+}
+
+func TestMemoryPayloadStoreBuckets(t *testing.T) {
+
+	// the same path split differently between bucket and key is a different payload
+	store := newMemoryPayloadStore()
+	ctx := context.Background()
+	_ = store.Put(ctx, "a", "b/c", []byte("first"))
+	_ = store.Put(ctx, "a/b", "c", []byte("second"))
+
+	payload, err := store.Get(ctx, "a", "b/c")
+	if err != nil || string(payload) != "first" {
+		t.Fatalf("Unexpected payload %s (%t)\n", payload, err)
+	}
+	listed, err := store.List(ctx, "a", "")
+	if err != nil || len(listed) != 1 || listed[0].Key != "b/c" {
+		t.Fatalf("Expected only the payload in the bucket to be listed, got %+v (%t)\n", listed, err)
+	}
+}
+
+func TestFilesystemPayloadStore(t *testing.T) {
+
+	store, err := NewFilesystemPayloadStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	verifyPayloadStore(t, store)
+}
+
+func TestFilesystemPayloadStoreBadLocation(t *testing.T) {
+
+	store, err := NewFilesystemPayloadStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	err = store.Put(context.Background(), payloadBucketName, "../escape", []byte("payload"))
+	if err != ErrBadPayloadLocation {
+		t.Fatalf("%t\n", err)
+	}
+
+	// partially written payloads are not in a bucket
+	err = store.Put(context.Background(), filesystemTempDir, payloadKeyName, []byte("payload"))
+	if err != ErrBadPayloadLocation {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+func TestFilesystemPayloadStoreTempNames(t *testing.T) {
+
+	store, err := NewFilesystemPayloadStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// a key may look like a temporary file name
+	ctx := context.Background()
+	for _, key := range []string{payloadKeyName + ".tmp", "." + payloadKeyName} {
+		err = store.Put(ctx, payloadBucketName, key, []byte("payload"))
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+	}
+	listed, err := store.(PayloadLister).List(ctx, payloadBucketName, "")
+	if err != nil || len(listed) != 2 {
+		t.Fatalf("Expected 2 payloads, got %+v (%t)\n", listed, err)
+	}
+}
+
+func TestS3PayloadStoreErrors(t *testing.T) {
+
+	// a missing payload is not found but a missing bucket is an error
+	ctx := context.Background()
+	err := s3Error(ctx, awserr.New(s3.ErrCodeNoSuchKey, "missing key", nil))
+	if err != ErrPayloadNotFound {
+		t.Fatalf("%t\n", err)
+	}
+	err = s3Error(ctx, awserr.New(s3.ErrCodeNoSuchBucket, "missing bucket", nil))
+	if err == nil || err == ErrPayloadNotFound {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+func TestFilesystemPayloadStoreLargeMessageContent(t *testing.T) {
+
+	store, err := NewFilesystemPayloadStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	inMemoryBackend(awssqs).store = store
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	count := uint(2)
+	messages := makeLargeMessages(count)
+	ops, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if allOperationsOK(ops) == false {
+		t.Fatalf("One or more put operations reported failed incorrectly\n")
+	}
+
+	messages = exactMessageGet(t, awssqs, queueHandle, count, goodWaitTime)
+	verifyMessages(t, messages)
+
+	// the message remembers where its payload is stored
+	bucket, key := messages[0].getBucketAttributes(messages[0].ReceiptHandle)
+	err = messages[0].DeleteOversizeMessage()
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	_, err = store.Get(context.Background(), bucket, key)
+	if err != ErrPayloadNotFound {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+//
+// helper methods
+//
+
+func verifyPayloadStore(t *testing.T, store PayloadStore) {
+
+	payload := randomPayload(smallMessageSize)
+	err := store.Put(context.Background(), payloadBucketName, payloadKeyName, payload)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	actual, err := store.Get(context.Background(), payloadBucketName, payloadKeyName)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if bytes.Equal(payload, actual) == false {
+		t.Fatalf("Stored payload differs from the original\n")
+	}
+
+	// our stores all list their payloads
+	listed, err := store.(PayloadLister).List(context.Background(), payloadBucketName, payloadKeyName[:8])
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(listed) != 1 || listed[0].Key != payloadKeyName || listed[0].Size != smallMessageSize || listed[0].LastModified.IsZero() == true {
+		t.Fatalf("Unexpected listed payloads %+v\n", listed)
+	}
+	listed, err = store.(PayloadLister).List(context.Background(), payloadBucketName, "other")
+	if err != nil || len(listed) != 0 {
+		t.Fatalf("Expected no listed payloads, got %d (%t)\n", len(listed), err)
+	}
+
+	err = store.Delete(context.Background(), payloadBucketName, payloadKeyName)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	_, err = store.Get(context.Background(), payloadBucketName, payloadKeyName)
+	if err != ErrPayloadNotFound {
+		t.Fatalf("%t\n", err)
+	}
+
+	// deleting again is not an error
+	err = store.Delete(context.Background(), payloadBucketName, payloadKeyName)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// our stores all stream their payloads
+	streamer := store.(PayloadStreamer)
+	err = streamer.PutStream(context.Background(), payloadBucketName, payloadKeyName, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	reader, err := streamer.GetStream(context.Background(), payloadBucketName, payloadKeyName)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	actual, err = io.ReadAll(reader)
+	reader.Close()
+	if err != nil || bytes.Equal(payload, actual) == false {
+		t.Fatalf("Streamed payload differs from the original (%t)\n", err)
+	}
+	_ = store.Delete(context.Background(), payloadBucketName, payloadKeyName)
+	_, err = streamer.GetStream(context.Background(), payloadBucketName, payloadKeyName)
+	if err != ErrPayloadNotFound {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,97 @@
+package awssqs
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+//
+// payload pointer format behavior tests
+//
+
+func TestPointerFormatConfigured(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	inMemoryBackend(awssqs).config.PointerFormat = PointerFormatPayloadS3Pointer
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePut(queueHandle, makeLargeMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// the message is sent in the newer format
+	sent := inMemoryQueueFor(awssqs, queueHandle).messages[0]
+	if strings.HasPrefix(sent.body, "[\""+payloadS3PointerMarkerTag+"\"") == false {
+		t.Fatalf("Unexpected payload pointer %s\n", sent.body)
+	}
+	if sent.attributes[extendedPayloadSizeAttributeName] == nil || sent.attributes[oversizeMessageAttributeName] != nil {
+		t.Fatalf("Expected only the %s attribute\n", extendedPayloadSizeAttributeName)
+	}
+
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(received) != 1 || received[0].IsOversize() == false {
+		t.Fatalf("Expected an oversize message\n")
+	}
+	verifyMessages(t, received)
+	if _, found := received[0].GetAttribute(extendedPayloadSizeAttributeName); found == true {
+		t.Fatalf("Expected the %s attribute to be removed\n", extendedPayloadSizeAttributeName)
+	}
+
+	// and sent again in the format it was received in
+	clone := received[0].redriveClone()
+	if strings.HasPrefix(string(clone.Payload), "[\""+payloadS3PointerMarkerTag+"\"") == false {
+		t.Fatalf("Unexpected redriven payload pointer %s\n", string(clone.Payload))
+	}
+	if _, found := clone.GetAttribute(oversizeMessageAttributeName); found == true {
+		t.Fatalf("Expected the redriven message to keep the newer format\n")
+	}
+}
+
+func TestPointerFormatReceived(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	store := inMemoryBackend(awssqs).store
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// messages in either format are received whatever format is configured
+	messages := makeLargeMessages(2)
+	formats := []PointerFormat{PointerFormatMessageS3Pointer, PointerFormatPayloadS3Pointer}
+	for ix, format := range formats {
+		err := messages[ix].convertToOversizeMessage(context.Background(), store, inMemoryMessageBucketName, string(format), format)
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+	}
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(received) != 2 || received[0].IsOversize() == false || received[1].IsOversize() == false {
+		t.Fatalf("Expected 2 oversize messages\n")
+	}
+	verifyMessages(t, received)
+
+	_, err = NewAwsSqs(AwsSqsConfig{MessageBucketName: inMemoryMessageBucketName, PointerFormat: "MessageS4Pointer"})
+	if err != ErrBadPointerFormat {
+		t.Fatalf("%t\n", err)
+	}
+	message := makeLargeMessage()
+	err = message.ConvertToOversizeMessageWithFormat(inMemoryMessageBucketName, "MessageS4Pointer")
+	if err != ErrBadPointerFormat {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+//
+// end of file
+//
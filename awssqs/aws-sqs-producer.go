@@ -0,0 +1,343 @@
+package awssqs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// the default longest a message waits for its block to fill before the block is sent
+var producerDefaultLinger = 100 * time.Millisecond
+
+// the default number of blocks sent concurrently
+var producerDefaultConcurrency = uint(4)
+
+// an estimate of the size of an oversize message once its payload has been replaced by the S3 marker
+var producerOversizeMessageSize = uint(1024)
+
+// ProducerCallback called with the outcome of sending a message, err is nil if the message was sent
+type ProducerCallback func(message Message, result BatchEntryResult, err error)
+
+// ProducerConfig our producer configuration structure
+type ProducerConfig struct {
+	Queue       QueueHandle   // the queue to send to
+	Linger      time.Duration // the longest a message waits for its block to fill (default 100 milliseconds)
+	Concurrency uint          // the number of blocks sent concurrently (default 4)
+}
+
+type ProducerFuture interface {
+
+	// Done closed once the outcome of the send is known
+	Done() <-chan struct{}
+
+	// Wait wait for the outcome of the send, returns nil if the message was sent, ErrMessageNotSent
+	// (with the reason in the result) if it was not or the error that prevented its block being sent.
+	// Cancelling the context abandons the wait but not the send
+	Wait(ctx context.Context) (BatchEntryResult, error)
+}
+
+type Producer interface {
+
+	// Send add a message to the current block, the block is sent once it is full (by count or size)
+	// or once the linger time has elapsed. Blocks if too many blocks are already being sent
+	Send(message Message) (ProducerFuture, error)
+
+	// SendWithCallback as Send, the callback is called with the outcome of the send
+	SendWithCallback(message Message, callback ProducerCallback) error
+
+	// Flush send the current block and wait for all the blocks being sent
+	Flush(ctx context.Context) error
+
+	// Close flush and stop accepting messages
+	Close(ctx context.Context) error
+}
+
+// NewProducer factory for our batching producer. The outcome of each message includes any retries made
+// according to the retry policy of the SQS implementation
+func NewProducer(sqs AWS_SQS, config ProducerConfig) (Producer, error) {
+
+	// validate the inbound configuration
+	if sqs == nil || len(config.Queue) == 0 {
+		return nil, ErrMissingConfiguration
+	}
+	csqs, ok := sqs.(AWS_SQS_Context)
+	if ok == false {
+		return nil, ErrContextNotSupported
+	}
+
+	// apply the defaults
+	if config.Linger == 0 {
+		config.Linger = producerDefaultLinger
+	}
+	if config.Concurrency == 0 {
+		config.Concurrency = producerDefaultConcurrency
+	}
+
+	return &producerImpl{
+		config: config,
+		sqs:    csqs,
+		log:    loggerFor(sqs),
+		slots:  make(chan struct{}, config.Concurrency),
+	}, nil
+}
+
+// this is our producer implementation, one block is filled at a time and full blocks are sent concurrently
+type producerImpl struct {
+	config ProducerConfig
+	sqs    AWS_SQS_Context
+	log    Logger
+	slots  chan struct{} // limits the number of blocks sent concurrently
+
+	mu         sync.Mutex
+	block      []producerEntry // the block being filled
+	blockSize  uint
+	generation uint // identifies the block being filled so a linger timer does not send a later block
+	closed     bool
+	sending    uint            // the number of blocks being sent
+	idle       []chan struct{} // closed once no blocks are being sent
+}
+
+// a message waiting to be sent and the future for its outcome
+type producerEntry struct {
+	message  Message
+	future   *producerFuture
+	callback ProducerCallback
+}
+
+// Send add a message to the current block
+func (p *producerImpl) Send(message Message) (ProducerFuture, error) {
+	future := newProducerFuture()
+	err := p.add(producerEntry{message: message, future: future})
+	if err != nil {
+		return nil, err
+	}
+	return future, nil
+}
+
+// SendWithCallback add a message to the current block, calling the callback with the outcome
+func (p *producerImpl) SendWithCallback(message Message, callback ProducerCallback) error {
+	return p.add(producerEntry{message: message, future: newProducerFuture(), callback: callback})
+}
+
+// Flush send the current block and wait for all the blocks being sent
+func (p *producerImpl) Flush(ctx context.Context) error {
+
+	p.mu.Lock()
+	full := p.takeBlock()
+	done := make(chan struct{})
+	if p.sending == 0 {
+		close(done)
+	} else {
+		p.idle = append(p.idle, done)
+	}
+	p.mu.Unlock()
+	p.dispatch(full)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// Close flush and stop accepting messages
+func (p *producerImpl) Close(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	return p.Flush(ctx)
+}
+
+//
+// implementation methods
+//
+
+// add an entry to the current block, sending the block if it is full
+func (p *producerImpl) add(entry producerEntry) error {
+
+	sz := p.sendSize(entry.message)
+
+	p.mu.Lock()
+	if p.closed == true {
+		p.mu.Unlock()
+		return ErrProducerClosed
+	}
+
+	// the block is full by size if this message does not fit
+	var full []producerEntry
+	if p.blockSize+sz > MAX_SQS_BLOCK_SIZE {
+		full = p.takeBlock()
+	}
+
+	p.block = append(p.block, entry)
+	p.blockSize += sz
+	if len(p.block) == 1 {
+		generation := p.generation
+		time.AfterFunc(p.config.Linger, func() { p.lingerExpired(generation) })
+	}
+
+	// the block is full by count
+	var fullByCount []producerEntry
+	if uint(len(p.block)) == MAX_SQS_BLOCK_COUNT {
+		fullByCount = p.takeBlock()
+	}
+	p.mu.Unlock()
+
+	p.dispatch(full)
+	p.dispatch(fullByCount)
+	return nil
+}
+
+// estimate the size of a message as it is sent, a message that will be stored as an oversize message is sent with
+// the S3 marker in place of its payload. A message that may be compressed enough to be sent with its payload could be
+// as large as any message. Uses the configuration of the SQS implementation if we know it
+func (p *producerImpl) sendSize(message Message) uint {
+
+	threshold := MAX_SQS_MESSAGE_SIZE
+	offload := false
+	compress := true
+	if awsi, ok := p.sqs.(*awsSqsImpl); ok == true {
+		threshold = awsi.oversizeThreshold()
+		offload = awsi.config.AlwaysOffload
+		compress = awsi.config.Compression != CompressionNone
+	}
+
+	// a message that is already oversize is sent as it is
+	sz := message.Size()
+	if message.IsOversize() == true {
+		return sz
+	}
+	if message.PayloadReader != nil || offload == true {
+		return producerOversizeMessageSize
+	}
+	if sz > threshold {
+		if compress == true && message.isCompressed() == false {
+			return MAX_SQS_MESSAGE_SIZE
+		}
+		return producerOversizeMessageSize
+	}
+	return sz
+}
+
+// send the block being filled when it was started this long ago
+func (p *producerImpl) lingerExpired(generation uint) {
+
+	p.mu.Lock()
+	var full []producerEntry
+	if generation == p.generation {
+		full = p.takeBlock()
+	}
+	p.mu.Unlock()
+	p.dispatch(full)
+}
+
+// take the block being filled to send it, call with the lock held
+func (p *producerImpl) takeBlock() []producerEntry {
+
+	if len(p.block) == 0 {
+		return nil
+	}
+	full := p.block
+	p.block = nil
+	p.blockSize = 0
+	p.generation++
+	p.sending++
+	return full
+}
+
+// note that a block has been sent, waking any flushes once no blocks are being sent
+func (p *producerImpl) blockSent() {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sending--
+	if p.sending == 0 {
+		for _, done := range p.idle {
+			close(done)
+		}
+		p.idle = nil
+	}
+}
+
+// send a block once there is a slot available to do so
+func (p *producerImpl) dispatch(block []producerEntry) {
+
+	if len(block) == 0 {
+		return
+	}
+
+	p.slots <- struct{}{}
+	go func() {
+		defer p.blockSent()
+		defer func() { <-p.slots }()
+		p.send(block)
+	}()
+}
+
+// send a block and resolve the future of each of its messages. We do not use the context of the
+// caller because the messages are sent after Send returns
+func (p *producerImpl) send(block []producerEntry) {
+
+	messages := make([]Message, 0, len(block))
+	for _, e := range block {
+		messages = append(messages, e.message)
+	}
+
+	results, err := p.sqs.BatchMessagePutWithResultWithContext(context.Background(), p.config.Queue, messages)
+	if err != nil && err != ErrOneOrMoreOperationsUnsuccessful {
+		p.log.Warn("send error", "queue", p.config.Queue, "total", len(block), "error", err)
+	}
+
+	for ix, e := range block {
+		switch {
+		case len(results) != len(block):
+			e.resolve(failedEntry(BatchEntryCodeRequestFailed, err.Error(), IsRetryableError(err)), err)
+		case results[ix].Success == false:
+			e.resolve(results[ix], ErrMessageNotSent)
+		default:
+			e.resolve(results[ix], nil)
+		}
+	}
+}
+
+// resolve the future of the entry and call its callback
+func (e producerEntry) resolve(result BatchEntryResult, err error) {
+	e.future.result = result
+	e.future.err = err
+	close(e.future.done)
+	if e.callback != nil {
+		e.callback(e.message, result, err)
+	}
+}
+
+//
+// producer future
+//
+
+type producerFuture struct {
+	done   chan struct{}
+	result BatchEntryResult
+	err    error
+}
+
+func newProducerFuture() *producerFuture {
+	return &producerFuture{done: make(chan struct{})}
+}
+
+func (f *producerFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *producerFuture) Wait(ctx context.Context) (BatchEntryResult, error) {
+	select {
+	case <-ctx.Done():
+		return BatchEntryResult{}, ctx.Err()
+	case <-f.done:
+		return f.result, f.err
+	}
+}
+
+//
+// end of file
+//
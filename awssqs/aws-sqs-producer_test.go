@@ -0,0 +1,223 @@
+package awssqs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// Producer behavior tests
+//
+
+func TestProducerFlushByCount(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	producer, _ := NewProducer(awssqs, ProducerConfig{Queue: queueHandle, Linger: time.Hour, Concurrency: 1})
+
+	futures := make([]ProducerFuture, 0)
+	for _, m := range makeStandardMessages(25) {
+		f, err := producer.Send(m)
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+		futures = append(futures, f)
+	}
+
+	// the full blocks are sent without waiting for the linger time
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, f := range futures[:20] {
+		_, err := f.Wait(ctx)
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+	}
+	select {
+	case <-futures[20].Done():
+		t.Fatalf("Expected the partial block to wait\n")
+	default:
+	}
+
+	err := producer.Close(ctx)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	for _, f := range futures[20:] {
+		result, err := f.Wait(ctx)
+		if err != nil || result.Success == false {
+			t.Fatalf("Expected the flushed message to be sent, got %v\n", err)
+		}
+	}
+	if svc.sends != 3 {
+		t.Fatalf("Expected 3 blocks to be sent, got %d\n", svc.sends)
+	}
+
+	_, err = producer.Send(makeStandardMessage())
+	if err != ErrProducerClosed {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+func TestProducerFlushBySize(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	producer, _ := NewProducer(awssqs, ProducerConfig{Queue: queueHandle, Linger: time.Hour, Concurrency: 1})
+
+	// each block can only hold two of these
+	for ix := 0; ix < 5; ix++ {
+		_, err := producer.Send(Message{Payload: randomPayload(MAX_SQS_BLOCK_SIZE/2 - 1024)})
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+	}
+	err := producer.Flush(context.Background())
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if svc.sends != 3 {
+		t.Fatalf("Expected 3 blocks to be sent, got %d\n", svc.sends)
+	}
+}
+
+func TestProducerOversizeThreshold(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	inMemoryBackend(awssqs).config.OversizeThreshold = 64 * 1024
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	producer, _ := NewProducer(awssqs, ProducerConfig{Queue: queueHandle, Linger: time.Hour, Concurrency: 1})
+
+	// these are sent with their payloads stored so a single block holds them all
+	for ix := 0; ix < 5; ix++ {
+		_, err := producer.Send(Message{Payload: randomPayload(MAX_SQS_BLOCK_SIZE/2 - 1024)})
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+	}
+	err := producer.Flush(context.Background())
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if svc.sends != 1 || svc.lastSendCount != 5 {
+		t.Fatalf("Expected 1 block to be sent, got %d\n", svc.sends)
+	}
+}
+
+func TestProducerOversizeCompressible(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	inMemoryBackend(awssqs).config.OversizeThreshold = 64 * 1024
+	inMemoryBackend(awssqs).config.Compression = CompressionGzip
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	producer, _ := NewProducer(awssqs, ProducerConfig{Queue: queueHandle, Linger: time.Hour, Concurrency: 1})
+
+	// these may be compressed enough to be sent with their payloads so each fills a block
+	for ix := 0; ix < 3; ix++ {
+		_, err := producer.Send(Message{Payload: randomPayload(MAX_SQS_BLOCK_SIZE/2 - 1024)})
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+	}
+	err := producer.Flush(context.Background())
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if svc.sends != 3 {
+		t.Fatalf("Expected 3 blocks to be sent, got %d\n", svc.sends)
+	}
+}
+
+func TestProducerLinger(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	producer, _ := NewProducer(awssqs, ProducerConfig{Queue: queueHandle, Linger: 10 * time.Millisecond})
+
+	future, _ := producer.Send(makeStandardMessage())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := future.Wait(ctx)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	available, _ := awssqs.GetMessagesAvailable(inMemoryQueueName)
+	if available != 1 {
+		t.Fatalf("Expected the message after the linger time, got %d\n", available)
+	}
+}
+
+func TestProducerCallback(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryFifoQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryFifoQueueName)
+	producer, _ := NewProducer(awssqs, ProducerConfig{Queue: queueHandle})
+
+	// FIFO queues do not support per-message delays so the delayed message is not sent
+	var mu sync.Mutex
+	outcomes := make(map[string]error)
+	messages := makeStandardMessages(2)
+	messages[0].MessageDeduplicationId = "sent"
+	messages[1].MessageDeduplicationId = "not-sent"
+	messages[1].Delay = time.Minute
+	for _, m := range messages {
+		err := producer.SendWithCallback(m, func(m Message, result BatchEntryResult, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			outcomes[m.MessageDeduplicationId] = err
+		})
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+	}
+
+	err := producer.Close(context.Background())
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(outcomes) != 2 || outcomes["sent"] != nil || outcomes["not-sent"] != ErrMessageNotSent {
+		t.Fatalf("Unexpected outcomes %v\n", outcomes)
+	}
+}
+
+func TestProducerConcurrentFlush(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	producer, _ := NewProducer(awssqs, ProducerConfig{Queue: queueHandle, Linger: time.Millisecond})
+
+	// blocks are taken to be sent while other goroutines are flushing
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for _, m := range makeStandardMessages(50) {
+				_, _ = producer.Send(m)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for ix := 0; ix < 50; ix++ {
+				_ = producer.Flush(context.Background())
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := producer.Close(context.Background())
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	available, _ := awssqs.GetMessagesAvailable(inMemoryQueueName)
+	if available != 200 {
+		t.Fatalf("Expected every message to be sent, got %d\n", available)
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,158 @@
+package awssqs
+
+import (
+	"context"
+	"testing"
+)
+
+var deadLetterQueueName = "virgo4-ingest-test-in-memory-dlq"
+
+//
+// dead letter queue and BatchMessageRedrive behavior tests
+//
+
+func TestRedrivePolicy(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName, deadLetterQueueName)
+	admin, _ := NewAwsSqsAdminFor(awssqs)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	deadLetterHandle, _ := awssqs.QueueHandle(deadLetterQueueName)
+	ctx := context.Background()
+
+	policy, err := admin.GetRedrivePolicy(ctx, queueHandle)
+	if err != nil || len(policy.DeadLetterQueue) != 0 {
+		t.Fatalf("Expected no redrive policy (%t)\n", err)
+	}
+
+	err = admin.SetRedrivePolicy(ctx, queueHandle, RedrivePolicy{DeadLetterQueue: deadLetterHandle})
+	if err != ErrBadMaxReceiveCount {
+		t.Fatalf("%t\n", err)
+	}
+	err = admin.SetRedrivePolicy(ctx, queueHandle, RedrivePolicy{DeadLetterQueue: deadLetterHandle, MaxReceiveCount: 3})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	policy, err = admin.GetRedrivePolicy(ctx, queueHandle)
+	if err != nil || policy.DeadLetterQueue != deadLetterHandle || policy.MaxReceiveCount != 3 {
+		t.Fatalf("Unexpected redrive policy %+v (%t)\n", policy, err)
+	}
+
+	sources, err := admin.ListDeadLetterSourceQueues(ctx, deadLetterHandle)
+	if err != nil || len(sources) != 1 || sources[0] != queueHandle {
+		t.Fatalf("Unexpected source queues %v (%t)\n", sources, err)
+	}
+
+	// an empty policy removes it
+	err = admin.SetRedrivePolicy(ctx, queueHandle, RedrivePolicy{})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	policy, _ = admin.GetRedrivePolicy(ctx, queueHandle)
+	if len(policy.DeadLetterQueue) != 0 {
+		t.Fatalf("Expected the redrive policy to be removed\n")
+	}
+}
+
+func TestBatchMessageRedriveHappyDay(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName, deadLetterQueueName)
+	admin, _ := NewAwsSqsAdminFor(awssqs)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	deadLetterHandle, _ := awssqs.QueueHandle(deadLetterQueueName)
+	clock := useTestClock(awssqs)
+	ctx := context.Background()
+
+	maxReceiveCount := uint(2)
+	err := admin.SetRedrivePolicy(ctx, queueHandle, RedrivePolicy{DeadLetterQueue: deadLetterHandle, MaxReceiveCount: maxReceiveCount})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	_, err = awssqs.BatchMessagePut(queueHandle, append(makeSmallMessages(1), makeLargeMessages(1)...))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// fail to process the messages until they are moved to the dead letter queue
+	for ix := uint(0); ix < maxReceiveCount; ix++ {
+		messages, _ := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, zeroWaitTime)
+		if len(messages) != 2 {
+			t.Fatalf("Expected 2 messages, received %d\n", len(messages))
+		}
+		clock.advance(inMemoryDefaultVisibilityTimeout)
+	}
+	messages, _ := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, zeroWaitTime)
+	if len(messages) != 0 {
+		t.Fatalf("Expected the messages to be moved to the dead letter queue\n")
+	}
+
+	deadLetters, err := awssqs.BatchMessageGet(deadLetterHandle, MAX_SQS_BLOCK_COUNT, zeroWaitTime)
+	if err != nil || len(deadLetters) != 2 {
+		t.Fatalf("Expected 2 dead letter messages, received %d (%t)\n", len(deadLetters), err)
+	}
+	verifyMessages(t, deadLetters)
+
+	results, err := awssqs.BatchMessageRedriveWithContext(ctx, deadLetterHandle, queueHandle, deadLetters)
+	if err != nil || results.AllSuccessful() == false {
+		t.Fatalf("Unexpected redrive results %+v (%t)\n", results, err)
+	}
+
+	// the messages (including the oversize payload) are back where they started
+	clock.advance(inMemoryDefaultVisibilityTimeout)
+	available, _ := awssqs.GetMessagesAvailable(deadLetterQueueName)
+	if available != 0 {
+		t.Fatalf("Expected an empty dead letter queue, found %d message(s)\n", available)
+	}
+	messages, err = awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, zeroWaitTime)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("Expected 2 redriven messages, received %d (%t)\n", len(messages), err)
+	}
+	verifyMessages(t, messages)
+	for _, m := range messages {
+		if m.ReceiveCount != 1 {
+			t.Fatalf("Expected redriven messages to start again\n")
+		}
+	}
+
+	// and processing them deletes the oversize payload as usual
+	_, err = awssqs.BatchMessageDelete(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	if store.count() != 0 {
+		t.Fatalf("Expected no oversize payloads, found %d\n", store.count())
+	}
+}
+
+func TestBatchMessageRedriveIncomplete(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName, deadLetterQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	deadLetterHandle, _ := awssqs.QueueHandle(deadLetterQueueName)
+
+	_, err := awssqs.BatchMessagePut(deadLetterHandle, makeSmallMessages(2))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	deadLetters, _ := awssqs.BatchMessageGet(deadLetterHandle, MAX_SQS_BLOCK_COUNT, zeroWaitTime)
+	deadLetters[1].Incomplete = true
+
+	results, err := awssqs.BatchMessageRedriveWithContext(context.Background(), deadLetterHandle, queueHandle, deadLetters)
+	if err != ErrOneOrMoreOperationsUnsuccessful {
+		t.Fatalf("%t\n", err)
+	}
+	if results[0].Success == false || results[1].Code != BatchEntryCodeIncompleteMessage {
+		t.Fatalf("Unexpected redrive results %+v\n", results)
+	}
+
+	available, _ := awssqs.GetMessagesAvailable(inMemoryQueueName)
+	if available != 1 {
+		t.Fatalf("Expected 1 message available, found %d\n", available)
+	}
+}
+
+//
+// end of file
+//
@@ -0,0 +1,281 @@
+package awssqs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// IsRetryableError is the error one that may not occur if the operation is retried. Throttling errors, server
+// (5xx) errors and transient network errors are retryable, cancellation and errors caused by the request are not
+func IsRetryableError(err error) bool {
+
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) == false || aerr.Code() == request.CanceledErrorCode {
+		return false
+	}
+	if request.IsErrorThrottle(err) == true || request.IsErrorRetryable(err) == true {
+		return true
+	}
+
+	var failure awserr.RequestFailure
+	return errors.As(err, &failure) == true && failure.StatusCode() >= 500
+}
+
+// was the request rejected by the service, in which case none of its entries were processed. Without a
+// response the request may have been processed
+func requestRejected(err error) bool {
+	var failure awserr.RequestFailure
+	return errors.As(err, &failure) == true && failure.StatusCode() < 500
+}
+
+// the retry policy used if none is configured, each operation is attempted once and MessagePutRetry
+// waits the initial delay between its attempts
+var noRetryPolicy = RetryPolicy{
+	MaxAttempts:  1,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     100 * time.Millisecond,
+	Multiplier:   1,
+}
+
+// apply the defaults to the configured policy
+func newRetryPolicy(policy RetryPolicy) RetryPolicy {
+
+	if policy.MaxAttempts == 0 && policy.InitialDelay == 0 && policy.MaxDelay == 0 &&
+		policy.Multiplier == 0 && policy.Jitter == 0 && policy.RetryableError == nil {
+		return noRetryPolicy
+	}
+
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if policy.InitialDelay == 0 {
+		policy.InitialDelay = DefaultRetryPolicy.InitialDelay
+	}
+	if policy.MaxDelay == 0 {
+		policy.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if policy.Multiplier == 0 {
+		policy.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	policy.Jitter = math.Max(0, math.Min(1, policy.Jitter))
+	return policy
+}
+
+// is the error retryable according to the policy
+func (p RetryPolicy) retryable(err error) bool {
+	if p.RetryableError != nil {
+		return p.RetryableError(err)
+	}
+	return IsRetryableError(err)
+}
+
+// the delay before the specified retry (the first retry is retry 1)
+func (p RetryPolicy) delay(retry uint) time.Duration {
+
+	d := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(retry-1))
+	if p.Jitter != 0 {
+		d = d * (1 - p.Jitter + (2 * p.Jitter * rand.Float64()))
+	}
+	return time.Duration(math.Min(d, float64(p.MaxDelay)))
+}
+
+// wait before the specified retry, returns the context error if it is cancelled while waiting
+func (p RetryPolicy) wait(ctx context.Context, retry uint) error {
+
+	timer := time.NewTimer(p.delay(retry))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// make the operation, retrying it while it fails with a retryable error
+func (p RetryPolicy) do(ctx context.Context, op func() error) error {
+
+	err := op()
+	for attempt := uint(1); err != nil && attempt < p.MaxAttempts && p.retryable(err) == true; attempt++ {
+		if p.wait(ctx, attempt) != nil {
+			return err
+		}
+		err = op()
+	}
+	return err
+}
+
+// make a batch operation, retrying the whole batch while the request fails with a retryable error and the
+// retryable entries while some entries fail. The messages are updated as the operation updates them
+func (awsi *awsSqsImpl) retryBatch(ctx context.Context, messages []Message, op func([]Message) (BatchResult, error)) (BatchResult, error) {
+
+	// nothing to retry
+	if len(messages) == 0 {
+		return op(messages)
+	}
+
+	results := make(BatchResult, len(messages))
+	pending := make([]int, 0, len(messages))
+	for ix := range messages {
+		pending = append(pending, ix)
+	}
+
+	var requestErr, entryErr error
+	reported := false
+	for attempt := uint(1); ; attempt++ {
+
+		batch := make([]Message, 0, len(pending))
+		for _, ix := range pending {
+			batch = append(batch, messages[ix])
+		}
+		res, err := op(batch)
+		for bx, ix := range pending {
+			messages[ix] = batch[bx]
+		}
+
+		if len(res) != len(batch) {
+			// the request failed so the pending entries are still pending
+			requestErr = err
+			if awsi.retry.retryable(err) == false {
+				break
+			}
+		} else {
+			// note the result of each entry and which of them to retry
+			requestErr = nil
+			reported = true
+			if err != nil && err != ErrOneOrMoreOperationsUnsuccessful {
+				entryErr = err
+			}
+			retry := make([]int, 0, len(pending))
+			for bx, ix := range pending {
+				results[ix] = res[bx]
+				if retryableEntry(res[bx]) == true {
+					retry = append(retry, ix)
+				}
+			}
+			pending = retry
+			if len(pending) == 0 {
+				break
+			}
+		}
+
+		if attempt >= awsi.retry.MaxAttempts {
+			break
+		}
+		awsi.log.Info("retrying", "items", len(pending), "attempt", attempt+1)
+		if err := awsi.retry.wait(ctx, attempt); err != nil {
+			if reported == false {
+				return emptyBatchResult, err
+			}
+			break
+		}
+	}
+
+	// if no request succeeded then the operation failed
+	if requestErr != nil {
+		if reported == false {
+			return emptyBatchResult, requestErr
+		}
+		for _, ix := range pending {
+			results[ix] = failedEntry(BatchEntryCodeRequestFailed, requestErr.Error(), awsi.retry.retryable(requestErr))
+		}
+	}
+
+	// if any of the entries are failures, return an error indicating so
+	if results.AllSuccessful() == false {
+		if entryErr != nil {
+			return results, entryErr
+		}
+		return results, ErrOneOrMoreOperationsUnsuccessful
+	}
+	return results, nil
+}
+
+// the result of part of a batch, if the request for that part failed then each of its entries failed
+func (awsi *awsSqsImpl) requestFailureResult(results BatchResult, sz int, err error) BatchResult {
+
+	if len(results) == sz {
+		return results
+	}
+	results = make(BatchResult, sz)
+	for ix := range results {
+		results[ix] = failedEntry(BatchEntryCodeRequestFailed, err.Error(), awsi.retry.retryable(err))
+	}
+	return results
+}
+
+// should a failed batch entry be retried. Payload store failures are not because the payload store
+// operations are retried themselves
+func retryableEntry(result BatchEntryResult) bool {
+	return result.Success == false && result.Retryable == true && result.Code != BatchEntryCodePayloadStoreFailure
+}
+
+//
+// retrying payload store, retries the operations of another payload store
+//
+
+type retryingPayloadStore struct {
+	store  PayloadStore
+	policy RetryPolicy
+}
+
+func (s *retryingPayloadStore) Put(ctx context.Context, bucket string, key string, payload []byte) error {
+	return s.policy.do(ctx, func() error {
+		return s.store.Put(ctx, bucket, key, payload)
+	})
+}
+
+func (s *retryingPayloadStore) Get(ctx context.Context, bucket string, key string) ([]byte, error) {
+	var payload []byte
+	err := s.policy.do(ctx, func() error {
+		var err error
+		payload, err = s.store.Get(ctx, bucket, key)
+		return err
+	})
+	return payload, err
+}
+
+func (s *retryingPayloadStore) Delete(ctx context.Context, bucket string, key string) error {
+	return s.policy.do(ctx, func() error {
+		return s.store.Delete(ctx, bucket, key)
+	})
+}
+
+// a streamed payload is only read once so cannot be retried
+func (s *retryingPayloadStore) PutStream(ctx context.Context, bucket string, key string, payload io.Reader) error {
+	streamer, ok := s.store.(PayloadStreamer)
+	if ok == false {
+		return ErrPayloadStreamNotSupported
+	}
+	return streamer.PutStream(ctx, bucket, key, payload)
+}
+
+func (s *retryingPayloadStore) GetStream(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	streamer, ok := s.store.(PayloadStreamer)
+	if ok == false {
+		return nil, ErrPayloadStreamNotSupported
+	}
+	var payload io.ReadCloser
+	err := s.policy.do(ctx, func() error {
+		var err error
+		payload, err = streamer.GetStream(ctx, bucket, key)
+		return err
+	})
+	return payload, err
+}
+
+//
+// end of file
+//
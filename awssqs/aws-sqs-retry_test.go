@@ -0,0 +1,291 @@
+package awssqs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// a fast retry policy for testing
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 2}
+
+//
+// retry policy behavior tests
+//
+
+func TestRetryThrottledRequests(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	svc.throttle = 1
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, makeSmallMessages(5))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if svc.sends != 2 {
+		t.Fatalf("Expected the throttled send to be retried (%d sends)\n", svc.sends)
+	}
+
+	svc.throttle = 1
+	messages, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(messages) != 5 || svc.receives != 2 {
+		t.Fatalf("Expected the throttled receive to be retried (%d messages, %d receives)\n", len(messages), svc.receives)
+	}
+
+	svc.throttle = 1
+	_, err = awssqs.BatchMessageDeleteWithResultWithContext(context.Background(), queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if svc.deletes != 2 {
+		t.Fatalf("Expected the throttled delete to be retried (%d deletes)\n", svc.deletes)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	svc.throttle = 10
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	results, err := awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, makeSmallMessages(1))
+	if IsRetryableError(err) == false || len(results) != 0 {
+		t.Fatalf("Expected the throttling error after giving up, got %v\n", err)
+	}
+	if svc.sends != testRetryPolicy.MaxAttempts {
+		t.Fatalf("Expected %d sends, got %d\n", testRetryPolicy.MaxAttempts, svc.sends)
+	}
+}
+
+func TestRetryFailedEntries(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// entries that failed through no fault of the sender are retried by themselves
+	svc.failEntry = "1"
+	svc.senderFault = false
+	results, err := awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, makeSmallMessages(3))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if results.AllSuccessful() == false || svc.sends != 2 || svc.lastSendCount != 1 {
+		t.Fatalf("Expected the failed entry alone to be retried (%d sends of %d)\n", svc.sends, svc.lastSendCount)
+	}
+
+	// entries that failed through the fault of the sender are not
+	svc.sends = 0
+	svc.failEntry = "1"
+	svc.senderFault = true
+	results, err = awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, makeSmallMessages(3))
+	if err != ErrOneOrMoreOperationsUnsuccessful {
+		t.Fatalf("%t\n", err)
+	}
+	if results[1].Success == true || results[1].Retryable == true || svc.sends != 1 {
+		t.Fatalf("Expected the failed entry not to be retried (%d sends)\n", svc.sends)
+	}
+}
+
+func TestRetryPayloadStore(t *testing.T) {
+
+	awssqs, _ := newFlakySqs()
+	store := &flakyPayloadStore{PayloadStore: newMemoryPayloadStore(), failures: 1}
+	inMemoryBackend(awssqs).store = store
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	_, err := awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, makeLargeMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if store.puts != 2 {
+		t.Fatalf("Expected the failed payload put to be retried (%d puts)\n", store.puts)
+	}
+}
+
+func TestRetryNotConfigured(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	inMemoryBackend(awssqs).retry = newRetryPolicy(RetryPolicy{})
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// without a retry policy a put is attempted once, MessagePutRetry makes each further attempt
+	svc.failEntry = "0"
+	messages := makeStandardMessages(2)
+	ops, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != ErrOneOrMoreOperationsUnsuccessful || svc.sends != 1 {
+		t.Fatalf("Expected a single attempt, got %d (%t)\n", svc.sends, err)
+	}
+	err = awssqs.MessagePutRetry(queueHandle, messages, ops, 2)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if svc.sends != 2 {
+		t.Fatalf("Expected 2 attempts, got %d\n", svc.sends)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+
+	policy := newRetryPolicy(RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second})
+	if policy.MaxAttempts != DefaultRetryPolicy.MaxAttempts || policy.Multiplier != DefaultRetryPolicy.Multiplier {
+		t.Fatalf("Expected the default attempts and multiplier, got %v\n", policy)
+	}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for ix, d := range expected {
+		if policy.delay(uint(ix+1)) != d {
+			t.Fatalf("Expected retry %d to be delayed %s, got %s\n", ix+1, d, policy.delay(uint(ix+1)))
+		}
+	}
+
+	policy.Jitter = 0.5
+	for retry := uint(1); retry < 100; retry++ {
+		d := policy.delay(1)
+		if d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("Jittered delay %s out of range\n", d)
+		}
+	}
+}
+
+func TestRetryableErrors(t *testing.T) {
+
+	retryable := []error{
+		awserr.New("ThrottlingException", "slow down", nil),
+		awserr.NewRequestFailure(awserr.New("InternalError", "oops", nil), 500, "id"),
+		awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "oops", nil), 503, "id"),
+	}
+	for _, err := range retryable {
+		if IsRetryableError(err) == false {
+			t.Fatalf("Expected %v to be retryable\n", err)
+		}
+	}
+
+	notRetryable := []error{
+		nil,
+		context.Canceled,
+		context.DeadlineExceeded,
+		ErrBadQueueHandle,
+		fmt.Errorf("payload store failure"),
+		awserr.NewRequestFailure(awserr.New(sqs.ErrCodeQueueDoesNotExist, "gone", nil), 400, "id"),
+	}
+	for _, err := range notRetryable {
+		if IsRetryableError(err) == true {
+			t.Fatalf("Expected %v not to be retryable\n", err)
+		}
+	}
+}
+
+//
+// helper methods
+//
+
+// create an in-memory SQS implementation with a flaky service and a fast retry policy
+func newFlakySqs() (AWS_SQS_Context, *flakySqsService) {
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	backend := inMemoryBackend(awssqs)
+	svc := &flakySqsService{SQSAPI: backend.svc}
+	backend.svc = svc
+	backend.retry = testRetryPolicy
+	return awssqs, svc
+}
+
+// an SQS service that throttles a number of requests and can fail a send entry once
+type flakySqsService struct {
+	sqsiface.SQSAPI
+	throttle      int
+	failEntry     string
+	senderFault   bool
+	sends         uint
+	lastSendCount int
+	receives      int
+	deletes       int
+	loseResponse  context.CancelFunc
+}
+
+func (f *flakySqsService) throttled() error {
+	if f.throttle == 0 {
+		return nil
+	}
+	f.throttle--
+	return awserr.NewRequestFailure(awserr.New("ThrottlingException", "rate exceeded", nil), 400, "id")
+}
+
+func (f *flakySqsService) SendMessageBatchWithContext(ctx aws.Context, input *sqs.SendMessageBatchInput, opts ...request.Option) (*sqs.SendMessageBatchOutput, error) {
+	f.sends++
+	f.lastSendCount = len(input.Entries)
+	if err := f.throttled(); err != nil {
+		return nil, err
+	}
+
+	// fail the requested entry once
+	entries := make([]*sqs.SendMessageBatchRequestEntry, 0, len(input.Entries))
+	var failed []*sqs.BatchResultErrorEntry
+	for _, e := range input.Entries {
+		if aws.StringValue(e.Id) == f.failEntry {
+			failed = append(failed, &sqs.BatchResultErrorEntry{Id: e.Id, Code: aws.String("InternalError"),
+				Message: aws.String("failed"), SenderFault: aws.Bool(f.senderFault)})
+			f.failEntry = ""
+			continue
+		}
+		entries = append(entries, e)
+	}
+	output, err := f.SQSAPI.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{Entries: entries, QueueUrl: input.QueueUrl}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// cancel the request once it is sent, as if it timed out waiting for the response
+	if f.loseResponse != nil {
+		f.loseResponse()
+		f.loseResponse = nil
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+	}
+	output.Failed = append(output.Failed, failed...)
+	return output, nil
+}
+
+func (f *flakySqsService) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	f.receives++
+	if err := f.throttled(); err != nil {
+		return nil, err
+	}
+	return f.SQSAPI.ReceiveMessageWithContext(ctx, input, opts...)
+}
+
+func (f *flakySqsService) DeleteMessageBatchWithContext(ctx aws.Context, input *sqs.DeleteMessageBatchInput, opts ...request.Option) (*sqs.DeleteMessageBatchOutput, error) {
+	f.deletes++
+	if err := f.throttled(); err != nil {
+		return nil, err
+	}
+	return f.SQSAPI.DeleteMessageBatchWithContext(ctx, input, opts...)
+}
+
+// a payload store that fails a number of puts with a server error
+type flakyPayloadStore struct {
+	PayloadStore
+	failures int
+	puts     int
+}
+
+func (s *flakyPayloadStore) Put(ctx context.Context, bucket string, key string, payload []byte) error {
+	s.puts++
+	if s.failures != 0 {
+		s.failures--
+		return awserr.NewRequestFailure(awserr.New("InternalError", "oops", nil), 500, "id")
+	}
+	return s.PayloadStore.Put(ctx, bucket, key, payload)
+}
+
+//
+// end of file
+//
@@ -0,0 +1,107 @@
+package awssqs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+//
+// failed put rollback behavior tests
+//
+
+func TestRollbackFailedEntry(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// the failed oversize message has its payload deleted and is restored
+	svc.failEntry = "1"
+	svc.senderFault = true
+	messages := append(makeLargeMessages(1), makeLargeMessage())
+	expected := messages[1].Payload
+	attributes := len(messages[1].Attribs)
+	ops, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != ErrOneOrMoreOperationsUnsuccessful {
+		t.Fatalf("%t\n", err)
+	}
+	if store.count() != 1 {
+		t.Fatalf("Expected only the sent payload to be stored, got %d\n", store.count())
+	}
+	if messages[0].IsOversize() == false || messages[1].IsOversize() == true {
+		t.Fatalf("Expected only the sent message to be oversize\n")
+	}
+	if bytes.Equal(messages[1].Payload, expected) == false || len(messages[1].Attribs) != attributes {
+		t.Fatalf("Expected the failed message to be restored\n")
+	}
+
+	// so retrying it stores its payload once
+	err = awssqs.MessagePutRetry(queueHandle, messages, ops, 1)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if store.count() != 2 {
+		t.Fatalf("Expected 2 stored payloads, got %d\n", store.count())
+	}
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil || len(received) != 2 {
+		t.Fatalf("Expected 2 messages, got %d (%t)\n", len(received), err)
+	}
+	verifyMessages(t, received)
+}
+
+func TestRollbackFailedRequest(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	inMemoryBackend(awssqs).config.Compression = CompressionGzip
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// every message is restored, including the compressed one
+	svc.throttle = 10
+	compressible := Message{Payload: bytes.Repeat([]byte("compressible "), 100000)}
+	messages := []Message{makeLargeMessage(), compressible, makeSmallMessage()}
+	expected := [][]byte{messages[0].Payload, messages[1].Payload, messages[2].Payload}
+	_, err := awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, messages)
+	if IsRetryableError(err) == false {
+		t.Fatalf("%t\n", err)
+	}
+	if store.count() != 0 {
+		t.Fatalf("Expected no stored payloads, got %d\n", store.count())
+	}
+	for ix, m := range messages {
+		if m.IsOversize() == true || m.isCompressed() == true || bytes.Equal(m.Payload, expected[ix]) == false {
+			t.Fatalf("Expected message %d to be restored\n", ix)
+		}
+	}
+}
+
+func TestRollbackUnconfirmedRequest(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// a message that may have been enqueued keeps its payload
+	ctx, cancel := context.WithCancel(context.Background())
+	svc.loseResponse = cancel
+	messages := makeLargeMessages(1)
+	_, err := awssqs.BatchMessagePutWithResultWithContext(ctx, queueHandle, messages)
+	if err != context.Canceled {
+		t.Fatalf("%t\n", err)
+	}
+	if store.count() != 1 || messages[0].IsOversize() == false {
+		t.Fatalf("Expected the payload of the unconfirmed message to be kept\n")
+	}
+
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil || len(received) != 1 {
+		t.Fatalf("Expected 1 message, got %d (%t)\n", len(received), err)
+	}
+	verifyMessages(t, received)
+}
+
+//
+// end of file
+//
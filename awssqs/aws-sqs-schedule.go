@@ -0,0 +1,107 @@
+package awssqs
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// messages delayed for longer than the maximum SQS delay are sent with these attributes and re-sent each
+// time they are received until their delivery time arrives. Neither attribute is seen by the receiver
+var scheduledDeliveryAttributeName = "SQSScheduledDeliveryTime" // epoch time in milliseconds
+var scheduledHopCountAttributeName = "SQSScheduledHopCount"     // the number of times the message has been re-sent
+
+// schedule the long delayed message for delivery relative to the supplied time. A message that is already
+// scheduled (because its send is being retried) keeps its original delivery time
+func (m *Message) schedule(now time.Time) {
+
+	if _, found := m.GetAttribute(scheduledDeliveryAttributeName); found == true {
+		return
+	}
+	deliverAt := now.Add(m.Delay).UnixMilli()
+	m.setNumberAttribute(scheduledDeliveryAttributeName, strconv.FormatInt(deliverAt, 10))
+	m.setNumberAttribute(scheduledHopCountAttributeName, "0")
+}
+
+// the delay to send the message with, scheduled messages are delayed for as long as possible
+// until their delivery time
+func (m *Message) sendDelay(now time.Time) time.Duration {
+
+	delay := m.Delay
+	if v, found := m.GetAttribute(scheduledDeliveryAttributeName); found == true {
+		deliverAt, _ := strconv.ParseInt(v, 10, 64)
+		delay = time.UnixMilli(deliverAt).Sub(now)
+	}
+	return time.Duration(math.Max(0, math.Min(float64(delay), float64(time.Duration(MAX_SQS_DELAY)*time.Second))))
+}
+
+// remove the scheduling attributes from a message that has been delivered
+func (m *Message) unschedule() {
+	m.deleteAttribute(scheduledDeliveryAttributeName)
+	m.deleteAttribute(scheduledHopCountAttributeName)
+}
+
+func (m *Message) setNumberAttribute(attribute string, value string) {
+	m.deleteAttribute(attribute)
+	m.Attribs = append(m.Attribs, Attribute{Name: attribute, Value: value, DataType: AttributeDataTypeNumber})
+}
+
+// is the received message a scheduled message that is not yet due. Delays are in whole seconds so
+// messages due within the next second are delivered
+func isScheduledHop(awsMessage *sqs.Message, now time.Time) bool {
+
+	v, found := awsMessage.MessageAttributes[scheduledDeliveryAttributeName]
+	if found == false {
+		return false
+	}
+	deliverAt, err := strconv.ParseInt(aws.StringValue(v.StringValue), 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.UnixMilli(deliverAt).Sub(now) >= time.Second
+}
+
+// re-send received scheduled messages that are not yet due and delete the originals. The message content is
+// sent as received so the payload of an oversize message is not read or copied. Messages that cannot be
+// re-sent are left to reappear on the queue and be re-sent then
+func (awsi *awsSqsImpl) resendScheduledHops(ctx context.Context, queue QueueHandle, hops []*sqs.Message) {
+
+	outbound := make([]Message, 0, len(hops))
+	for _, h := range hops {
+		m := Message{Attribs: makeAttributes(h.MessageAttributes), Payload: []byte(aws.StringValue(h.Body)), resend: true}
+		count, _ := m.GetAttribute(scheduledHopCountAttributeName)
+		hop, _ := strconv.Atoi(count)
+		m.setNumberAttribute(scheduledHopCountAttributeName, strconv.Itoa(hop+1))
+		outbound = append(outbound, m)
+	}
+
+	sent, err := awsi.BatchMessagePutWithResultWithContext(ctx, queue, outbound)
+	if err != nil && err != ErrOneOrMoreOperationsUnsuccessful {
+		awsi.log.Warn("failed re-sending scheduled messages", "queue", queue, "error", err)
+		return
+	}
+
+	toDelete := make([]Message, 0, len(hops))
+	for ix, r := range sent {
+		if r.Success == true {
+			toDelete = append(toDelete, Message{ReceiptHandle: ReceiptHandle(aws.StringValue(hops[ix].ReceiptHandle))})
+		}
+	}
+	if len(toDelete) != len(hops) {
+		awsi.log.Warn("failed re-sending scheduled messages", "queue", queue, "failed", len(hops)-len(toDelete))
+	}
+	if len(toDelete) != 0 {
+		_, err = awsi.batchMessageDelete(ctx, queue, toDelete, false)
+		if err != nil {
+			awsi.log.Warn("failed deleting re-sent scheduled messages", "queue", queue, "error", err)
+		}
+	}
+}
+
+//
+// end of file
+//
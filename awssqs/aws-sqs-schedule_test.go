@@ -0,0 +1,174 @@
+package awssqs
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//
+// delayed and scheduled message behavior tests
+//
+
+func TestDelayedMessage(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	messages := makeStandardMessages(1)
+	messages[0].Delay = 90 * time.Second
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	stats, err := awssqs.GetQueueStatsWithContext(context.Background(), queueHandle)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if stats.Delayed != 1 || stats.Visible != 0 {
+		t.Fatalf("Expected one delayed message, got %+v\n", stats)
+	}
+
+	received, _ := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if len(received) != 0 {
+		t.Fatalf("Received a delayed message early\n")
+	}
+
+	clock.advance(90 * time.Second)
+	received, _ = awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if len(received) != 1 {
+		t.Fatalf("Expected the delayed message after the delay, got %d\n", len(received))
+	}
+}
+
+func TestDelayedMessageNotValid(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName, inMemoryFifoQueueName)
+	fifoHandle, _ := awssqs.QueueHandle(inMemoryFifoQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	messages := makeStandardMessages(2)
+	messages[1].Delay = time.Minute
+	results, err := awssqs.BatchMessagePutWithResultWithContext(context.Background(), fifoHandle, messages)
+	if err != ErrOneOrMoreOperationsUnsuccessful {
+		t.Fatalf("%t\n", err)
+	}
+	if results[0].Success == false || results[1].Code != BatchEntryCodeInvalidDelay {
+		t.Fatalf("Expected the delayed FIFO message alone to fail, got %+v\n", results)
+	}
+
+	messages = makeStandardMessages(1)
+	messages[0].Delay = -time.Minute
+	results, _ = awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, messages)
+	if results[0].Code != BatchEntryCodeInvalidDelay {
+		t.Fatalf("Expected a negative delay to fail, got %+v\n", results)
+	}
+}
+
+func TestScheduledMessage(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	messages := append(makeStandardMessages(1), makeLargeMessages(1)...)
+	expected := [][]byte{messages[0].Payload, messages[1].Payload}
+	attributes := []int{len(messages[0].Attribs), len(messages[1].Attribs)}
+	for ix := range messages {
+		messages[ix].Delay = 40 * time.Minute
+	}
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// the messages are re-sent each time they become visible until they are due
+	for hop := 1; hop <= 2; hop++ {
+		clock.advance(time.Duration(MAX_SQS_DELAY) * time.Second)
+		received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+		if len(received) != 0 {
+			t.Fatalf("Received a scheduled message early (hop %d)\n", hop)
+		}
+		queue := inMemoryQueueFor(awssqs, queueHandle)
+		if len(queue.messages) != 2 || queue.messages[0].receiveCount != 0 {
+			t.Fatalf("Expected the scheduled messages to be re-sent (hop %d)\n", hop)
+		}
+		count := queue.messages[0].attributes[scheduledHopCountAttributeName].StringValue
+		if *count != strconv.Itoa(hop) {
+			t.Fatalf("Expected hop count %d, got %s\n", hop, *count)
+		}
+	}
+
+	// the final hop is shorter and delivers the messages without the scheduling attributes
+	clock.advance(10 * time.Minute)
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(received) != 2 {
+		t.Fatalf("Expected the scheduled messages when due, got %d\n", len(received))
+	}
+	for ix, m := range received {
+		if bytes.Equal(m.Payload, expected[ix]) == false {
+			t.Fatalf("Unexpected scheduled message payload\n")
+		}
+		if len(m.Attribs) != attributes[ix] {
+			t.Fatalf("Expected the scheduling attributes to be removed, got %v\n", m.Attribs)
+		}
+	}
+	if received[1].IsOversize() == false {
+		t.Fatalf("Expected the oversize scheduled message to remain oversize\n")
+	}
+}
+
+func TestScheduledMessageAlwaysOffload(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+	inMemoryBackend(awssqs).config.AlwaysOffload = true
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// the re-sent messages refer to the payloads stored when they were first sent
+	messages := makeStandardMessages(2)
+	expected := [][]byte{messages[0].Payload, messages[1].Payload}
+	for ix := range messages {
+		messages[ix].Delay = 40 * time.Minute
+	}
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	for hop := 1; hop <= 2; hop++ {
+		clock.advance(time.Duration(MAX_SQS_DELAY) * time.Second)
+		_, err = awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+		if err != nil {
+			t.Fatalf("%t\n", err)
+		}
+		if store.count() != 2 {
+			t.Fatalf("Expected the re-sent payloads not to be stored again, got %d (hop %d)\n", store.count(), hop)
+		}
+	}
+
+	clock.advance(10 * time.Minute)
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil || len(received) != 2 {
+		t.Fatalf("Expected the scheduled messages when due, got %d (%t)\n", len(received), err)
+	}
+	for ix, m := range received {
+		if m.IsOversize() == false || bytes.Equal(m.Payload, expected[ix]) == false {
+			t.Fatalf("Unexpected scheduled message payload\n")
+		}
+	}
+}
+
+//
+// end of file
+//
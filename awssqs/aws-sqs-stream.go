@@ -0,0 +1,160 @@
+package awssqs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+)
+
+// the attribute marking an oversize message whose payload is streamed, it is not read until it is opened
+var streamedPayloadAttributeName = "SQSStreamedPayload"
+
+// IsStreamed is the payload of this message streamed, a received streamed message has no Payload
+func (m *Message) IsStreamed() bool {
+	_, found := m.payloadAttribute(streamedPayloadAttributeName)
+	return found
+}
+
+// OpenPayload open the payload for reading. The payload of a streamed message is read from the payload store
+// as the returned reader is read, the reader reports ErrMismatchedContentsSize or ErrChecksumMismatch instead
+// of io.EOF if the payload is not the one that was sent. Other payloads are read from the message
+func (m *Message) OpenPayload(ctx context.Context) (io.ReadCloser, error) {
+
+	// not yet sent
+	if m.PayloadReader != nil && m.oversize == false {
+		return io.NopCloser(m.PayloadReader), nil
+	}
+
+	if m.IsStreamed() == false {
+		return io.NopCloser(bytes.NewReader(m.Payload)), nil
+	}
+
+	// use the store the payload was written to or read from if we know it
+	store := m.store
+	if store == nil {
+		store = defaultPayloadStore
+	}
+	streamer, ok := store.(PayloadStreamer)
+	if ok == false {
+		return nil, ErrPayloadStreamNotSupported
+	}
+
+	bucket, key := m.getBucketAttributes(m.ReceiptHandle)
+	if bucket == "" || key == "" {
+		return nil, ErrBadReceiptHandle
+	}
+	payload, err := streamer.GetStream(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// the size and checksum are verified once the payload has been read
+	reader := &verifyingReader{reader: payload, size: -1, digest: md5.New()}
+	for _, name := range []string{oversizeMessageAttributeName, extendedPayloadSizeAttributeName} {
+		if v, found := m.payloadAttribute(name); found == true {
+			reader.size, _ = strconv.ParseInt(v, 10, 64)
+		}
+	}
+	reader.checksum = m.checksum
+	return reader, nil
+}
+
+// convert to an oversize message by storing the streamed payload as it is read, returns the digest of the
+// payload used as its content deduplication id. The reader is consumed even if this fails
+func (m *Message) convertToStreamedMessage(ctx context.Context, store PayloadStore, bucket string, key string, format PointerFormat) (string, error) {
+
+	streamer, ok := store.(PayloadStreamer)
+	if ok == false {
+		return "", ErrPayloadStreamNotSupported
+	}
+
+	// checksum the payload as it is stored
+	checksum := md5.New()
+	digest := sha256.New()
+	counted := &countingReader{reader: io.TeeReader(m.PayloadReader, io.MultiWriter(checksum, digest))}
+	m.PayloadReader = consumedPayloadReader{}
+	err := streamer.PutStream(ctx, bucket, key, counted)
+	if err != nil {
+		return "", err
+	}
+
+	m.PayloadReader = nil
+	m.markOversize(store, bucket, key, int(counted.count), fmt.Sprintf("%x", checksum.Sum(nil)), format)
+	m.addAttribute(streamedPayloadAttributeName, "true")
+	return fmt.Sprintf("%x", digest.Sum(nil)), nil
+}
+
+// has the streamed payload of a message that was not sent been read
+func (m *Message) payloadConsumed() bool {
+	_, consumed := m.PayloadReader.(consumedPayloadReader)
+	return consumed || m.PayloadReader == nil
+}
+
+// get an attribute describing the stored payload, these are transport attributes once the message is received
+func (m *Message) payloadAttribute(name string) (string, bool) {
+
+	for _, a := range m.transport {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return m.GetAttribute(name)
+}
+
+// counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	count  uint
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += uint(n)
+	return n, err
+}
+
+// replaces the reader of a streamed payload once it has been read
+type consumedPayloadReader struct{}
+
+func (consumedPayloadReader) Read(p []byte) (int, error) {
+	return 0, ErrPayloadStreamConsumed
+}
+
+// verifies the size and checksum of a streamed payload once it has been read, a negative size or empty
+// checksum is not verified
+type verifyingReader struct {
+	reader   io.ReadCloser
+	size     int64
+	checksum string
+	digest   hash.Hash
+	count    int64
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+
+	n, err := r.reader.Read(p)
+	r.digest.Write(p[:n])
+	r.count += int64(n)
+	if err == io.EOF {
+		if r.size >= 0 && r.count != r.size {
+			return n, ErrMismatchedContentsSize
+		}
+		if len(r.checksum) != 0 && fmt.Sprintf("%x", r.digest.Sum(nil)) != r.checksum {
+			return n, ErrChecksumMismatch
+		}
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.reader.Close()
+}
+
+//
+// end of file
+//
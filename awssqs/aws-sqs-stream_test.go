@@ -0,0 +1,119 @@
+package awssqs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+//
+// streamed payload behavior tests
+//
+
+func TestStreamedPayload(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	ctx := context.Background()
+
+	streamed := randomPayload(largeMessageSize)
+	messages := []Message{{PayloadReader: bytes.NewReader(streamed)}, makeStandardMessage()}
+	_, err := awssqs.BatchMessagePut(queueHandle, messages)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if messages[0].IsStreamed() == false || messages[0].PayloadReader != nil || store.count() != 1 {
+		t.Fatalf("Expected the streamed payload to be stored\n")
+	}
+
+	// the streamed payload is not read until it is opened
+	received, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil || len(received) != 2 {
+		t.Fatalf("Expected 2 messages, got %d (%t)\n", len(received), err)
+	}
+	if received[0].IsStreamed() == false || len(received[0].Payload) != 0 || received[1].IsStreamed() == true {
+		t.Fatalf("Expected only the first message to be streamed\n")
+	}
+	for ix, expected := range [][]byte{streamed, received[1].Payload} {
+		payload := readPayload(t, received[ix])
+		if bytes.Equal(payload, expected) == false {
+			t.Fatalf("Unexpected payload for message %d\n", ix)
+		}
+	}
+
+	// a corrupted payload is reported once it has been read
+	bucket, key := received[0].getBucketAttributes(received[0].ReceiptHandle)
+	_ = store.Put(ctx, bucket, key, randomPayload(largeMessageSize))
+	reader, err := received[0].OpenPayload(ctx)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	_, err = io.ReadAll(reader)
+	if err != ErrChecksumMismatch {
+		t.Fatalf("%t\n", err)
+	}
+	reader.Close()
+
+	_, err = awssqs.BatchMessageDelete(queueHandle, received)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if store.count() != 0 {
+		t.Fatalf("Expected the streamed payload to be deleted\n")
+	}
+}
+
+func TestStreamedPayloadNotSent(t *testing.T) {
+
+	awssqs, svc := newFlakySqs()
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+
+	// the stored payload of a message that is not sent is deleted and its reader cannot be read again
+	svc.failEntry = "0"
+	svc.senderFault = true
+	messages := []Message{{PayloadReader: bytes.NewReader(randomPayload(smallMessageSize))}}
+	results, err := awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, messages)
+	if err != ErrOneOrMoreOperationsUnsuccessful || results[0].Success == true {
+		t.Fatalf("%t\n", err)
+	}
+	if store.count() != 0 || messages[0].IsStreamed() == true {
+		t.Fatalf("Expected the streamed payload to be deleted\n")
+	}
+	results, _ = awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, messages)
+	if results[0].Code != BatchEntryCodePayloadStoreFailure || results[0].Message != ErrPayloadStreamConsumed.Error() || results[0].Retryable == true {
+		t.Fatalf("Expected the consumed payload to fail, got %+v\n", results[0])
+	}
+
+	// streamed payloads cannot be encrypted
+	inMemoryBackend(awssqs).config.KeyProvider = makeKeyProvider(t, "key1")
+	messages = []Message{{PayloadReader: bytes.NewReader(randomPayload(smallMessageSize))}}
+	results, _ = awssqs.BatchMessagePutWithResultWithContext(context.Background(), queueHandle, messages)
+	if results[0].Code != BatchEntryCodeEncryptionFailure || results[0].Retryable == true {
+		t.Fatalf("Expected the streamed payload not to be encrypted, got %+v\n", results[0])
+	}
+}
+
+//
+// helper methods
+//
+
+func readPayload(t *testing.T, message Message) []byte {
+
+	reader, err := message.OpenPayload(context.Background())
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	defer reader.Close()
+	payload, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	return payload
+}
+
+//
+// end of file
+//
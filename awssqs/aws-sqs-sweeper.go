@@ -0,0 +1,264 @@
+package awssqs
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// the SQS default message retention period, used for queues that do not report theirs
+var sweeperDefaultRetentionPeriod = 4 * 24 * time.Hour
+
+// how long the messages received while scanning a queue stay hidden, they are made visible again once
+// the scan is complete
+var sweeperScanVisibility = 5 * time.Minute
+
+// how long each receive waits for messages while scanning a queue and how many receives in a row must find
+// nothing new before the scan is complete, a single receive does not sample every server holding the queue
+var sweeperScanWaitTime = 5 * time.Second
+var sweeperScanEmptyReceives = 3
+
+// PayloadSweeperConfig our payload sweeper configuration structure
+type PayloadSweeperConfig struct {
+	Queues     []QueueHandle // the queues sending messages with payloads in the bucket (include any dead letter queues)
+	Prefix     string        // only payloads whose keys begin with the prefix are swept (all if not specified)
+	MinimumAge time.Duration // younger payloads are kept (the longest retention period of the queues if not specified)
+	DryRun     bool          // report the orphaned payloads without deleting them
+
+	// WARNING: scanning disrupts the queues, see Sweep. Keep payloads referenced by the messages currently
+	// visible in the queues (not scanned if not specified, a generous minimum age is the safer choice)
+	ScanQueues bool
+}
+
+// SweepReport the outcome of a sweep
+type SweepReport struct {
+	Listed     uint            // the number of payloads listed
+	Orphaned   []StoredPayload // old payloads not referenced by any message in the queues, deleted unless a dry run
+	Referenced []StoredPayload // old payloads still referenced by a message in the queues, never deleted
+	Deleted    uint            // the number of orphaned payloads deleted
+}
+
+type PayloadSweeper interface {
+
+	// Sweep delete the payloads in the message bucket that are older than the minimum age. The payload of a
+	// message is deleted along with it so any payload older than the retention period of the queues has outlived
+	// its message, unless the message was redriven or scheduled since these refer to the original payload.
+	// Use a minimum age longer than the retention period plus the longest schedule and the longest time a
+	// message may be redriven after it was first sent, the queues are not touched.
+	// Scanning the queues is opt-in and disrupts them: every visible message is received (and released once the
+	// scan is complete) so receive counts go up, which can move messages to a dead letter queue, and consumers
+	// cannot receive the messages during the scan. Even then messages in flight with a consumer, delayed
+	// messages and scheduled messages waiting for their next hop are not visible so they cannot be scanned.
+	// Returns the report so far along with any error, ErrOneOrMoreOperationsUnsuccessful if any payload could
+	// not be deleted
+	Sweep(ctx context.Context) (SweepReport, error)
+}
+
+// NewPayloadSweeper factory for our orphaned payload sweeper, it uses the message bucket and payload store of
+// the SQS implementation. The payload store must be a PayloadLister
+func NewPayloadSweeper(sqs AWS_SQS, config PayloadSweeperConfig) (PayloadSweeper, error) {
+
+	// validate the inbound configuration
+	awsi, ok := sqs.(*awsSqsImpl)
+	if ok == false || (len(config.Queues) == 0 && config.MinimumAge == 0) {
+		return nil, ErrMissingConfiguration
+	}
+	lister, ok := awsi.store.(PayloadLister)
+	if ok == false {
+		return nil, ErrPayloadListNotSupported
+	}
+	if config.ScanQueues == true {
+		awsi.log.Warn("payload sweeper scans the queues, their messages are received which adds to their receive counts and hides them from consumers during the scan")
+	}
+
+	return &payloadSweeperImpl{config: config, awsi: awsi, lister: lister}, nil
+}
+
+// this is our payload sweeper implementation
+type payloadSweeperImpl struct {
+	config PayloadSweeperConfig
+	awsi   *awsSqsImpl
+	lister PayloadLister
+}
+
+// Sweep delete the orphaned payloads
+func (s *payloadSweeperImpl) Sweep(ctx context.Context) (SweepReport, error) {
+
+	report := SweepReport{Orphaned: make([]StoredPayload, 0), Referenced: make([]StoredPayload, 0)}
+
+	minimumAge, err := s.minimumAge(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	bucket := s.awsi.config.MessageBucketName
+	payloads, err := s.lister.List(ctx, bucket, s.config.Prefix)
+	if err != nil {
+		return report, err
+	}
+	report.Listed = uint(len(payloads))
+
+	// the payloads old enough to have outlived their messages
+	cutoff := s.awsi.now().Add(-minimumAge)
+	candidates := make([]StoredPayload, 0)
+	for _, p := range payloads {
+		if p.LastModified.Before(cutoff) == true {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return report, nil
+	}
+
+	// keep those still referenced by a message
+	referenced := make(map[string]bool)
+	if s.config.ScanQueues == true {
+		for _, queue := range s.config.Queues {
+			err = s.scanQueue(ctx, queue, referenced)
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+	for _, p := range candidates {
+		if referenced[p.Key] == true {
+			report.Referenced = append(report.Referenced, p)
+		} else {
+			report.Orphaned = append(report.Orphaned, p)
+		}
+	}
+
+	if s.config.DryRun == true {
+		return report, nil
+	}
+
+	// orphaned payloads are not deleted on behalf of any queue
+	store := s.awsi.storeFor("")
+	for _, p := range report.Orphaned {
+		err = store.Delete(ctx, bucket, p.Key)
+		if err != nil {
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			s.awsi.log.Warn("failed deleting orphaned payload", "bucket", bucket, "key", p.Key, "error", err)
+			continue
+		}
+		report.Deleted++
+	}
+
+	if report.Deleted != uint(len(report.Orphaned)) {
+		return report, ErrOneOrMoreOperationsUnsuccessful
+	}
+	return report, nil
+}
+
+// the configured minimum age or the longest retention period of the queues
+func (s *payloadSweeperImpl) minimumAge(ctx context.Context) (time.Duration, error) {
+
+	if s.config.MinimumAge != 0 {
+		return s.config.MinimumAge, nil
+	}
+
+	admin := &awsSqsAdminImpl{s.awsi.svc, s.awsi.handles}
+	longest := time.Duration(0)
+	for _, queue := range s.config.Queues {
+		attributes, err := admin.GetQueueAttributes(ctx, queue, sqs.QueueAttributeNameMessageRetentionPeriod)
+		if err != nil {
+			return 0, err
+		}
+		retention := sweeperDefaultRetentionPeriod
+		if v, found := attributes[sqs.QueueAttributeNameMessageRetentionPeriod]; found == true {
+			seconds, err := strconv.Atoi(v)
+			if err != nil {
+				return 0, err
+			}
+			retention = time.Duration(seconds) * time.Second
+		}
+		if retention > longest {
+			longest = retention
+		}
+	}
+	return longest, nil
+}
+
+// receive every visible message in the queue, noting the payload keys of the oversize messages, then
+// make the messages visible again
+func (s *payloadSweeperImpl) scanQueue(ctx context.Context, queue QueueHandle, referenced map[string]bool) error {
+
+	q := string(queue)
+	receipts := make(map[string]ReceiptHandle) // the latest receipt handle of each message, keyed by message id
+
+	// release whatever we received however the scan ends
+	defer func() { s.release(queue, receipts) }()
+
+	empty := 0
+	for empty < sweeperScanEmptyReceives {
+		result, err := s.awsi.svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			MessageAttributeNames: []*string{
+				aws.String(sqs.QueueAttributeNameAll),
+			},
+			QueueUrl:            &q,
+			MaxNumberOfMessages: aws.Int64(int64(MAX_SQS_BLOCK_COUNT)),
+			VisibilityTimeout:   aws.Int64(int64(sweeperScanVisibility.Seconds())),
+			WaitTimeSeconds:     aws.Int64(int64(sweeperScanWaitTime.Seconds())),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		// the scan is complete once several receives in a row find nothing new
+		fresh := 0
+		for _, m := range result.Messages {
+			id := aws.StringValue(m.MessageId)
+			_, seen := receipts[id]
+			receipts[id] = ReceiptHandle(aws.StringValue(m.ReceiptHandle))
+			if seen == true {
+				continue
+			}
+			fresh++
+
+			message := Message{Attribs: makeAttributes(m.MessageAttributes), Payload: []byte(aws.StringValue(m.Body))}
+			if _, found := message.oversizeSizeAttribute(); found == true {
+				bucket, key, _, err := message.decodeS3MarkerInformation(message.Payload, s.awsi.log)
+				if err == nil && bucket == s.awsi.config.MessageBucketName {
+					referenced[key] = true
+				}
+			}
+		}
+		if fresh == 0 {
+			empty++
+		} else {
+			empty = 0
+		}
+	}
+	return nil
+}
+
+// make the scanned messages visible again
+func (s *payloadSweeperImpl) release(queue QueueHandle, receipts map[string]ReceiptHandle) {
+
+	messages := make([]Message, 0, len(receipts))
+	for _, r := range receipts {
+		messages = append(messages, Message{ReceiptHandle: r})
+	}
+
+	// use a fresh context so the messages are released even if the scan was cancelled
+	ctx := context.Background()
+	for start := 0; start < len(messages); start += int(MAX_SQS_BLOCK_COUNT) {
+		end := min(start+int(MAX_SQS_BLOCK_COUNT), len(messages))
+		_, err := s.awsi.BatchMessageVisibilityChangeWithContext(ctx, queue, messages[start:end], 0)
+		if err != nil {
+			s.awsi.log.Warn("failed releasing scanned messages", "queue", queueNameFromHandle(queue), "error", err)
+		}
+	}
+}
+
+//
+// end of file
+//
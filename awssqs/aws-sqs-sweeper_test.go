@@ -0,0 +1,155 @@
+package awssqs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+//
+// payload sweeper behavior tests
+//
+
+func TestSweeperOrphanedPayloads(t *testing.T) {
+
+	useScanWaitTime(t, 0)
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+	admin, _ := NewAwsSqsAdminFor(awssqs)
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	ctx := context.Background()
+
+	err := admin.SetQueueAttributes(ctx, queueHandle, map[string]string{sqs.QueueAttributeNameMessageRetentionPeriod: "3600"})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	// an old payload still referenced by a message, an old orphan and a new orphan
+	_, err = awssqs.BatchMessagePut(queueHandle, makeLargeMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	_ = store.Put(ctx, inMemoryMessageBucketName, "old-orphan", randomPayload(10))
+	clock.advance(2 * time.Hour)
+	_ = store.Put(ctx, inMemoryMessageBucketName, "new-orphan", randomPayload(10))
+
+	sweeper, err := NewPayloadSweeper(awssqs, PayloadSweeperConfig{Queues: []QueueHandle{queueHandle}, DryRun: true, ScanQueues: true})
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	report, err := sweeper.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if report.Listed != 3 || len(report.Orphaned) != 1 || report.Orphaned[0].Key != "old-orphan" || len(report.Referenced) != 1 {
+		t.Fatalf("Unexpected sweep report %+v\n", report)
+	}
+	if report.Deleted != 0 || store.count() != 3 {
+		t.Fatalf("Expected a dry run to delete nothing\n")
+	}
+
+	// the scanned message is available again
+	messages, err := awssqs.BatchMessageGet(queueHandle, MAX_SQS_BLOCK_COUNT, 0)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("Expected the scanned message to be released, got %d (%t)\n", len(messages), err)
+	}
+	verifyMessages(t, messages)
+	_, _ = awssqs.BatchMessageVisibilityChange(queueHandle, messages, 0)
+
+	sweeper, _ = NewPayloadSweeper(awssqs, PayloadSweeperConfig{Queues: []QueueHandle{queueHandle}, ScanQueues: true})
+	report, err = sweeper.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if report.Deleted != 1 || store.count() != 2 {
+		t.Fatalf("Expected the old orphan to be deleted, got %+v\n", report)
+	}
+	if _, err = store.Get(ctx, inMemoryMessageBucketName, "old-orphan"); err != ErrPayloadNotFound {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+func TestSweeperMessageReturning(t *testing.T) {
+
+	useScanWaitTime(t, time.Second)
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	ctx := context.Background()
+
+	// a message in flight that becomes visible again while the queue is being scanned
+	_, err := awssqs.BatchMessagePut(queueHandle, makeLargeMessages(1))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	messages, _ := awssqs.BatchMessageGet(queueHandle, 1, zeroWaitTime)
+	_, err = awssqs.BatchMessageVisibilityChange(queueHandle, messages, time.Second)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+
+	sweeper, _ := NewPayloadSweeper(awssqs, PayloadSweeperConfig{Queues: []QueueHandle{queueHandle}, MinimumAge: time.Nanosecond, DryRun: true, ScanQueues: true})
+	report, err := sweeper.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if len(report.Referenced) != 1 || len(report.Orphaned) != 0 {
+		t.Fatalf("Expected the returning message payload to be referenced, got %+v\n", report)
+	}
+}
+
+func TestSweeperMinimumAge(t *testing.T) {
+
+	awssqs := NewInMemorySqs(inMemoryQueueName)
+	clock := useTestClock(awssqs)
+	store := inMemoryBackend(awssqs).store.(*memoryPayloadStore)
+	queueHandle, _ := awssqs.QueueHandle(inMemoryQueueName)
+	ctx := context.Background()
+
+	// without scanning the queues every old payload is swept
+	_, err := awssqs.BatchMessagePut(queueHandle, makeLargeMessages(2))
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	clock.advance(time.Hour)
+	observer := &recordingObserver{}
+	inMemoryBackend(awssqs).observer = observer
+	sweeper, _ := NewPayloadSweeper(awssqs, PayloadSweeperConfig{MinimumAge: 30 * time.Minute})
+	report, err := sweeper.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("%t\n", err)
+	}
+	if report.Deleted != 2 || store.count() != 0 {
+		t.Fatalf("Expected 2 payloads to be deleted, got %+v\n", report)
+	}
+	if observer.count(OperationPayloadDelete) != 2 {
+		t.Fatalf("Expected the payload deletes to be observed\n")
+	}
+
+	_, err = NewPayloadSweeper(awssqs, PayloadSweeperConfig{})
+	if err != ErrMissingConfiguration {
+		t.Fatalf("%t\n", err)
+	}
+	inMemoryBackend(awssqs).store = failingPayloadStore{}
+	_, err = NewPayloadSweeper(awssqs, PayloadSweeperConfig{MinimumAge: time.Hour})
+	if err != ErrPayloadListNotSupported {
+		t.Fatalf("%t\n", err)
+	}
+}
+
+//
+// helper methods
+//
+
+// use a different scan receive wait time for the duration of the test
+func useScanWaitTime(t *testing.T, wait time.Duration) {
+	previous := sweeperScanWaitTime
+	sweeperScanWaitTime = wait
+	t.Cleanup(func() { sweeperScanWaitTime = previous })
+}
+
+//
+// end of file
+//
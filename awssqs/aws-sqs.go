@@ -1,7 +1,10 @@
 package awssqs
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"time"
 )
 
@@ -17,17 +20,60 @@ var MAX_SQS_MESSAGE_SIZE = MAX_SQS_BLOCK_SIZE
 // the maximum queue wait time (in seconds)
 var MAX_SQS_WAIT_TIME = uint(20)
 
+// the maximum number of messages received at a time using parallel receives
+var MAX_SQS_PARALLEL_BLOCK_COUNT = uint(1000)
+
+// the maximum message delay (in seconds), longer delays are made by re-sending the message
+var MAX_SQS_DELAY = uint(900)
+
+// the maximum message visibility timeout (in seconds)
+var MAX_SQS_VISIBILITY_TIMEOUT = uint(43200)
+
+// the maximum number of queues listed at a time
+var MAX_SQS_LIST_QUEUES_PAGE = uint(1000)
+
+// the maximum receive count of a redrive policy
+var MAX_SQS_RECEIVE_COUNT = uint(1000)
+
+// the maximum number of message attributes, including those added to oversize, compressed, encrypted,
+// streamed and scheduled messages
+var MAX_SQS_ATTRIBUTE_COUNT = uint(10)
+
 // Errors
 var ErrBlockCountTooLarge = fmt.Errorf("block count is too large. Must be %d or less", MAX_SQS_BLOCK_COUNT)
+var ErrParallelBlockCountTooLarge = fmt.Errorf("block count is too large. Must be %d or less", MAX_SQS_PARALLEL_BLOCK_COUNT)
 var ErrBlockTooLarge = fmt.Errorf("block size is too large. Must be %d or less", MAX_SQS_BLOCK_SIZE)
 var ErrMessageTooLarge = fmt.Errorf("message size is too large. Must be %d or less", MAX_SQS_MESSAGE_SIZE)
+var ErrTooManyAttributes = fmt.Errorf("message has too many attributes. Must be %d or less", MAX_SQS_ATTRIBUTE_COUNT)
 var ErrWaitTooLarge = fmt.Errorf("wait time is too large. Must be %d or less", MAX_SQS_WAIT_TIME)
+var ErrVisibilityTooLarge = fmt.Errorf("visibility timeout is too large. Must be %d or less", MAX_SQS_VISIBILITY_TIMEOUT)
+var ErrVisibilityTooSmall = fmt.Errorf("visibility timeout is too small. Must be at least 1 second")
+var ErrVisibilityNegative = fmt.Errorf("visibility timeout is negative. Must be 0 or more")
 var ErrBadQueueName = fmt.Errorf("queue name does not exist")
 var ErrBadQueueHandle = fmt.Errorf("queue handle is bad")
 var ErrOneOrMoreOperationsUnsuccessful = fmt.Errorf("one or more operations were not successful")
 var ErrBadReceiptHandle = fmt.Errorf("receipt handle format is incorrect for large message support")
 var ErrMismatchedContentsSize = fmt.Errorf("actual S3 message size differs from expected size")
 var ErrMissingConfiguration = fmt.Errorf("configuration information is incomplete")
+var ErrPayloadNotFound = fmt.Errorf("oversize message payload does not exist")
+var ErrBadPayloadLocation = fmt.Errorf("oversize message payload bucket or key is bad")
+var ErrPayloadListNotSupported = fmt.Errorf("payload store cannot list payloads")
+var ErrPayloadStreamNotSupported = fmt.Errorf("payload store cannot stream payloads")
+var ErrPayloadStreamConsumed = fmt.Errorf("streamed payload has already been read")
+var ErrBadFifoQueueName = fmt.Errorf("queue name is bad. FIFO queue names (and only FIFO queue names) must end in %s", fifoQueueSuffix)
+var ErrQueueExists = fmt.Errorf("queue already exists with different attributes")
+var ErrBadMaxReceiveCount = fmt.Errorf("maximum receive count is bad. Must be between 1 and %d", MAX_SQS_RECEIVE_COUNT)
+var ErrBadRedrivePolicy = fmt.Errorf("redrive policy format is incorrect")
+var ErrProducerClosed = fmt.Errorf("producer is closed")
+var ErrContextNotSupported = fmt.Errorf("SQS implementation does not support the AWS_SQS_Context operations")
+var ErrMessageNotSent = fmt.Errorf("message was not sent")
+var ErrBadCompression = fmt.Errorf("payload compression is not supported")
+var ErrNoKeyProvider = fmt.Errorf("message payload is encrypted and no key provider is configured")
+var ErrUnknownKey = fmt.Errorf("encryption key is unknown")
+var ErrBadKeyfile = fmt.Errorf("keyfile format is incorrect")
+var ErrChecksumMismatch = fmt.Errorf("message checksum does not match its content")
+var ErrBadPointerFormat = fmt.Errorf("payload pointer format is not supported")
+var ErrBadOversizeThreshold = fmt.Errorf("oversize threshold is too large. Must be %d or less", MAX_SQS_MESSAGE_SIZE)
 
 // standard attribute keys and values
 var AttributeKeyRecordId = "id"
@@ -40,29 +86,86 @@ var AttributeValueRecordTypeXml = "xml"
 var AttributeValueRecordOperationUpdate = "update"
 var AttributeValueRecordOperationDelete = "delete"
 
+// the message group used for FIFO messages that do not specify one
+var DefaultMessageGroupId = "default"
+
 // simplifications
 type QueueHandle string
 type ReceiptHandle string
 type OpStatus bool
 
+// failure codes for batch entries that fail before reaching SQS (otherwise the code is from SQS)
+var BatchEntryCodePayloadStoreFailure = "PayloadStoreFailure"
+var BatchEntryCodeBlockTooLarge = "BlockTooLarge"
+var BatchEntryCodeIncompleteMessage = "IncompleteMessage"
+var BatchEntryCodeNotDeleted = "NotDeleted"
+var BatchEntryCodeRequestFailed = "RequestFailed"
+var BatchEntryCodeInvalidDelay = "InvalidDelay"
+var BatchEntryCodeEncryptionFailure = "EncryptionFailure"
+var BatchEntryCodeChecksumMismatch = "ChecksumMismatch"
+var BatchEntryCodeTooManyAttributes = "TooManyAttributes"
+
+// BatchEntryResult the outcome of a single entry in a batch operation
+type BatchEntryResult struct {
+	Success     bool   // the operation was successful
+	Code        string // the failure code
+	Message     string // the failure description
+	SenderFault bool   // the failure was caused by the request rather than the service
+	Retryable   bool   // the operation may succeed if retried
+}
+
+// BatchResult the outcome of each entry in a batch operation, in the same order as the batch
+type BatchResult []BatchEntryResult
+
+// attribute data types, a custom type may be appended to any of them (e.g. Number.int)
+var AttributeDataTypeString = "String"
+var AttributeDataTypeNumber = "Number"
+var AttributeDataTypeBinary = "Binary"
+
 // just a KV pair
 type Attribute struct {
-	Name  string
-	Value string
+	Name        string
+	Value       string // the value of String and Number attributes
+	DataType    string // the data type (AttributeDataTypeString if not specified)
+	BinaryValue []byte // the value of Binary attributes
 }
 
 type Attributes []Attribute
 
 type Message struct {
+	// SQS allows MAX_SQS_ATTRIBUTE_COUNT attributes, sending a message adds one for an oversize message
+	// (two if it is streamed), one for a compressed payload, two for an encrypted payload and two for a
+	// scheduled message. A message with too many is not sent (BatchEntryCodeTooManyAttributes)
 	Attribs       Attributes
 	ReceiptHandle ReceiptHandle
 	FirstSent     uint64 // epoch time (http://en.wikipedia.org/wiki/Unix_time)
 	FirstReceived uint64 // epoch time (http://en.wikipedia.org/wiki/Unix_time)
+	ReceiveCount  uint   // the approximate number of times this message has been received
 	Payload       []byte
 	Incomplete    bool // this message is incomplete and may be handled differently
 
+	// if specified, the payload is read from here as it is stored as an oversize payload so it is never held in
+	// memory (Payload is ignored). Streamed payloads are not compressed and cannot be encrypted. The reader is
+	// read once so a streamed message that fails to send cannot be sent again. A received streamed message has
+	// no Payload, use OpenPayload to read it
+	PayloadReader io.Reader
+
+	// standard queues only, the message is not delivered until this delay has elapsed. Delays longer than
+	// MAX_SQS_DELAY are made by re-sending the message (using two of its ten attributes) until it is due
+	Delay time.Duration
+
+	// FIFO queues only, ignored for standard queues
+	MessageGroupId         string // messages in the same group are delivered in order (DefaultMessageGroupId if not specified)
+	MessageDeduplicationId string // messages with the same id sent within the deduplication interval are discarded
+	SequenceNumber         string // assigned by SQS, available on received messages
+
 	// used by the implementation
-	oversize bool // this is an oversize message and is handled differently
+	oversize  bool         // this is an oversize message and is handled differently
+	store     PayloadStore // where the oversize payload is stored
+	checksum  string       // the MD5 digest of the oversize payload, sent in the payload pointer
+	transport Attributes   // the attributes describing the stored payload and how it was compressed or encrypted
+	resend    bool         // sent again exactly as received, the payload is not compressed, encrypted or stored again
+	enqueued  bool         // the send failed in a way that may still have enqueued the message
 }
 
 type AWS_SQS interface {
@@ -79,7 +182,8 @@ type AWS_SQS interface {
 
 	// BatchMessagePut put a batch of messages to the specified queue.
 	// in the event of one or more failure, the operation status array will indicate which
-	// messages were processed successfully and which were not.
+	// messages were processed successfully and which were not. Messages that were not sent are left
+	// as they were and any oversize payload stored for them is deleted.
 	BatchMessagePut(queue QueueHandle, messages []Message) ([]OpStatus, error)
 
 	// BatchMessageDelete mark a batch of messages from the specified queue as suitable for delete. This mechanism
@@ -88,13 +192,278 @@ type AWS_SQS interface {
 
 	// MessagePutRetry retry a batched put after one or more of the operations fails.
 	// retry the specified amount of times and return an error of after retrying one or messages
-	// has still not been sent successfully.
+	// has still not been sent successfully. If a retry policy is configured each retry is itself
+	// retried according to the policy so use one or the other.
 	MessagePutRetry(queue QueueHandle, messages []Message, opStatus []OpStatus, retryCount uint) error
 }
 
+// AWS_SQS_Context the operations added to AWS_SQS, our implementations satisfy it so get it from an AWS_SQS
+// with a type assertion. Each operation has a form taking a context, cancelling the context abandons the
+// operation (including any long poll wait and any oversize message payload transfer) and returns the context error
+type AWS_SQS_Context interface {
+	AWS_SQS
+
+	// the AWS_SQS operations taking a context
+	QueueHandleWithContext(ctx context.Context, queueName string) (QueueHandle, error)
+	GetMessagesAvailableWithContext(ctx context.Context, queueName string) (uint, error)
+	BatchMessageGetWithContext(ctx context.Context, queue QueueHandle, maxMessages uint, waitTime time.Duration) ([]Message, error)
+	BatchMessagePutWithContext(ctx context.Context, queue QueueHandle, messages []Message) ([]OpStatus, error)
+	BatchMessageDeleteWithContext(ctx context.Context, queue QueueHandle, messages []Message) ([]OpStatus, error)
+	MessagePutRetryWithContext(ctx context.Context, queue QueueHandle, messages []Message, opStatus []OpStatus, retryCount uint) error
+
+	// GetQueueStats get the message counts and attributes of the specified queue in a single request
+	GetQueueStats(queue QueueHandle) (QueueStats, error)
+	GetQueueStatsWithContext(ctx context.Context, queue QueueHandle) (QueueStats, error)
+
+	// BatchMessageVisibilityChange change the visibility timeout of a batch of in-flight messages from the specified
+	// queue. The new timeout is measured from now and a timeout of zero makes the messages visible immediately.
+	BatchMessageVisibilityChange(queue QueueHandle, messages []Message, timeout time.Duration) ([]OpStatus, error)
+	BatchMessageVisibilityChangeWithContext(ctx context.Context, queue QueueHandle, messages []Message, timeout time.Duration) ([]OpStatus, error)
+
+	// BatchMessagePutWithResult and BatchMessageDeleteWithResult are the same as BatchMessagePut and
+	// BatchMessageDelete but report the reason for each failure and whether it can be retried rather than a
+	// simple status
+	BatchMessagePutWithResult(queue QueueHandle, messages []Message) (BatchResult, error)
+	BatchMessagePutWithResultWithContext(ctx context.Context, queue QueueHandle, messages []Message) (BatchResult, error)
+	BatchMessageDeleteWithResult(queue QueueHandle, messages []Message) (BatchResult, error)
+	BatchMessageDeleteWithResultWithContext(ctx context.Context, queue QueueHandle, messages []Message) (BatchResult, error)
+
+	// BatchMessageRedrive move a batch of messages received from a dead letter queue to the specified queue
+	// (normally the queue they came from). Each message is sent then deleted from the dead letter queue, the
+	// payload of an oversize message is neither copied nor deleted. Incomplete messages are not moved and a
+	// message that is sent but cannot be deleted (BatchEntryCodeNotDeleted) is in both queues.
+	BatchMessageRedrive(deadLetterQueue QueueHandle, queue QueueHandle, messages []Message) (BatchResult, error)
+	BatchMessageRedriveWithContext(ctx context.Context, deadLetterQueue QueueHandle, queue QueueHandle, messages []Message) (BatchResult, error)
+
+	// BatchMessageGetParallel get up to MAX_SQS_PARALLEL_BLOCK_COUNT messages from the specified queue by making
+	// several receives concurrently. Returns once it has the requested number of messages or the wait time has
+	// elapsed. Incomplete messages are handled as they are by BatchMessageGet and messages received before an
+	// error are returned along with it
+	BatchMessageGetParallel(queue QueueHandle, maxMessages uint, waitTime time.Duration) ([]Message, error)
+	BatchMessageGetParallelWithContext(ctx context.Context, queue QueueHandle, maxMessages uint, waitTime time.Duration) ([]Message, error)
+}
+
+// QueueConfig the configuration of a new queue
+type QueueConfig struct {
+	Fifo                      bool              // a FIFO queue, the queue name must end in .fifo
+	ContentBasedDeduplication bool              // FIFO queues only, derive deduplication ids from the message content
+	VisibilityTimeout         time.Duration     // the default visibility timeout (the SQS default if not specified)
+	Attributes                map[string]string // any other queue attributes, see the aws-sdk-go sqs.QueueAttributeName constants
+}
+
+// QueueStats the approximate message counts of a queue together with all of its attributes
+type QueueStats struct {
+	Visible    uint              // messages available for receipt
+	InFlight   uint              // messages received but not yet deleted or visible again
+	Delayed    uint              // messages sent but not yet available for receipt
+	Attributes map[string]string // all the queue attributes, see the aws-sdk-go sqs.QueueAttributeName constants
+}
+
+// RedrivePolicy the dead letter queue configuration of a queue
+type RedrivePolicy struct {
+	DeadLetterQueue QueueHandle // messages received too many times are moved here
+	MaxReceiveCount uint        // the number of times a message is received before it is moved
+}
+
+type AWS_SQS_ADMIN interface {
+
+	// CreateQueue create a queue, returning the queue handle. Creating a queue that already exists with the
+	// same attributes returns the existing queue handle
+	CreateQueue(ctx context.Context, queueName string, config QueueConfig) (QueueHandle, error)
+
+	// DeleteQueue delete a queue and any messages it contains
+	DeleteQueue(ctx context.Context, queue QueueHandle) error
+
+	// PurgeQueue delete all the messages in a queue (oversize message payloads are not deleted)
+	PurgeQueue(ctx context.Context, queue QueueHandle) error
+
+	// ListQueues list the queues whose names begin with the prefix (all queues if the prefix is empty)
+	ListQueues(ctx context.Context, prefix string) ([]QueueHandle, error)
+
+	// ListQueuesPage list a page of at most maxResults queues whose names begin with the prefix. Start with an
+	// empty token then pass the returned token to get the next page, the returned token is empty after the last page
+	ListQueuesPage(ctx context.Context, prefix string, maxResults uint, nextToken string) ([]QueueHandle, string, error)
+
+	// GetQueueAttributes get the named attributes of a queue (all attributes if none are named)
+	GetQueueAttributes(ctx context.Context, queue QueueHandle, names ...string) (map[string]string, error)
+
+	// SetQueueAttributes set the supplied attributes of a queue
+	SetQueueAttributes(ctx context.Context, queue QueueHandle, attributes map[string]string) error
+
+	// SetRedrivePolicy set the dead letter queue configuration of a queue, a policy without a dead
+	// letter queue removes any existing configuration
+	SetRedrivePolicy(ctx context.Context, queue QueueHandle, policy RedrivePolicy) error
+
+	// GetRedrivePolicy get the dead letter queue configuration of a queue, the policy is empty if there is none
+	GetRedrivePolicy(ctx context.Context, queue QueueHandle) (RedrivePolicy, error)
+
+	// ListDeadLetterSourceQueues list the queues that use the specified queue as their dead letter queue
+	ListDeadLetterSourceQueues(ctx context.Context, deadLetterQueue QueueHandle) ([]QueueHandle, error)
+}
+
+// PayloadStore the storage used for oversize message payloads
+type PayloadStore interface {
+
+	// Put store the payload at the specified bucket and key
+	Put(ctx context.Context, bucket string, key string, payload []byte) error
+
+	// Get get the payload stored at the specified bucket and key, ErrPayloadNotFound if there is none
+	Get(ctx context.Context, bucket string, key string) ([]byte, error)
+
+	// Delete delete the payload stored at the specified bucket and key, deleting a payload that does
+	// not exist is not an error
+	Delete(ctx context.Context, bucket string, key string) error
+}
+
+// StoredPayload a payload in a payload store
+type StoredPayload struct {
+	Key          string    // the payload key
+	Size         uint      // the payload size in bytes
+	LastModified time.Time // when the payload was stored
+}
+
+// PayloadLister a payload store that can list the payloads it stores, required to sweep orphaned payloads.
+// All of our payload stores are listers
+type PayloadLister interface {
+
+	// List list the payloads in the specified bucket whose keys begin with the prefix (all if the prefix is empty)
+	List(ctx context.Context, bucket string, prefix string) ([]StoredPayload, error)
+}
+
+// PayloadStreamer a payload store that can store and read payloads as streams, required for streamed payloads.
+// All of our payload stores are streamers
+type PayloadStreamer interface {
+
+	// PutStream store the payload read from the reader at the specified bucket and key
+	PutStream(ctx context.Context, bucket string, key string, payload io.Reader) error
+
+	// GetStream open the payload stored at the specified bucket and key for reading, ErrPayloadNotFound if
+	// there is none
+	GetStream(ctx context.Context, bucket string, key string) (io.ReadCloser, error)
+}
+
+// KeyProvider provides the keys used to encrypt message payloads. Each batch of payloads is encrypted with a
+// new data key which is sent with the messages, wrapped (encrypted) by a key known to the provider
+type KeyProvider interface {
+
+	// GenerateDataKey make a new 256 bit data key, returning the key, the wrapped key and the id of the
+	// key that wrapped it
+	GenerateDataKey(ctx context.Context) ([]byte, []byte, string, error)
+
+	// UnwrapDataKey unwrap a data key wrapped by the key with the specified id, ErrUnknownKey if the
+	// provider does not know the key
+	UnwrapDataKey(ctx context.Context, keyId string, wrappedKey []byte) ([]byte, error)
+}
+
+// Logger the logging interface used throughout, messages are accompanied by alternating keys and values.
+// A *slog.Logger satisfies this interface
+type Logger interface {
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// ObservedOperation the operations reported to an observer
+type ObservedOperation string
+
+var OperationReceive = ObservedOperation("ReceiveMessage")
+var OperationSend = ObservedOperation("SendMessageBatch")
+var OperationDelete = ObservedOperation("DeleteMessageBatch")
+var OperationVisibilityChange = ObservedOperation("ChangeMessageVisibilityBatch")
+var OperationBlockSplit = ObservedOperation("BlockSplit")
+var OperationPayloadPut = ObservedOperation("PayloadPut")
+var OperationPayloadGet = ObservedOperation("PayloadGet")
+var OperationPayloadDelete = ObservedOperation("PayloadDelete")
+
+// ObserverEvent describes a single observed operation
+type ObserverEvent struct {
+	Operation ObservedOperation
+	Queue     QueueHandle   // the queue the operation was on behalf of
+	Latency   time.Duration // how long the operation took (zero for a block split)
+	Messages  uint          // the number of messages (or payloads) in the operation
+	Bytes     uint          // the total size of the messages (or payloads) in the operation
+	Failures  uint          // the number of messages (or payloads) that were not successful
+	Err       error         // the error returned by the operation, if any
+}
+
+// Observer receives an event for each SQS request, each oversize payload transfer and each time a block
+// is split because it is too large. Observe is called synchronously so must not block. The prometheus
+// subpackage has an observer that maintains prometheus metrics
+type Observer interface {
+	Observe(event ObserverEvent)
+}
+
+// PayloadCompression how the payloads of messages that are too large to send are compressed
+type PayloadCompression string
+
+var CompressionNone = PayloadCompression("")
+var CompressionGzip = PayloadCompression("gzip")
+var CompressionZstd = PayloadCompression("zstd")
+
+// PointerFormat the format of the payload pointer sent in place of the payload of an oversize message. The
+// original Java extended client format is used by default, the newer Java and Python extended clients use the
+// payload offloading format. Messages in either format are received. The pointer also carries the MD5 digest of
+// the stored payload (s3PayloadMD5) which is verified when the payload is read
+type PointerFormat string
+
+var PointerFormatMessageS3Pointer = PointerFormat("MessageS3Pointer")
+var PointerFormatPayloadS3Pointer = PointerFormat("PayloadS3Pointer")
+
+// RetryPolicy how failed operations are retried. Put, delete and receive requests that fail with a retryable
+// error are retried, as are the put and delete batch entries that are reported as retryable. Oversize message
+// payload transfers are retried in the same way. Retrying is opt-in, with a zero value policy each operation is
+// attempted once. Otherwise any zero fields (except Jitter) use the values from DefaultRetryPolicy
+type RetryPolicy struct {
+	MaxAttempts  uint          // the maximum number of attempts, including the first (1 means do not retry)
+	InitialDelay time.Duration // the delay before the first retry
+	MaxDelay     time.Duration // the maximum delay between attempts
+	Multiplier   float64       // the delay is multiplied by this for each further retry
+	Jitter       float64       // each delay is randomly adjusted by up to this fraction of itself (0 to 1)
+
+	// decide if an error is retryable (IsRetryableError if not specified)
+	RetryableError func(error) bool
+}
+
+// a reasonable retry policy to configure, it is not used unless it is configured
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  3,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     5 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+}
+
 // AwsSqsConfig our configuration structure
 type AwsSqsConfig struct {
-	MessageBucketName string // the name of the bucket to use for oversize messages
+	MessageBucketName string       // the name of the bucket to use for oversize messages
+	PayloadStore      PayloadStore // where oversize payloads are stored (S3 if not specified)
+	Logger            Logger       // where we log (the standard log if not specified)
+	Observer          Observer     // notified of each operation (slow requests are logged if not specified)
+	RetryPolicy       RetryPolicy  // how failed operations are retried (not retried if not specified)
+
+	// messages larger than this are oversize and have their payloads stored (MAX_SQS_MESSAGE_SIZE if not
+	// specified). If AlwaysOffload is set, every message payload is stored
+	OversizeThreshold uint
+	AlwaysOffload     bool
+
+	// the prefix of the keys of stored payloads, the key is the prefix followed by a unique id. The prefix may
+	// include {queue} (the queue name), {date} (YYYY-MM-DD), {year}, {month} and {day} which are replaced when
+	// the payload is stored, for example "{queue}/{date}/" (no prefix if not specified)
+	PayloadKeyPrefix string
+
+	// the format of the payload pointer of oversize messages (PointerFormatMessageS3Pointer if not specified)
+	PointerFormat PointerFormat
+
+	// the payloads of messages that are too large to send are compressed and only stored if they are
+	// still too large once compressed (never compressed if not specified)
+	Compression PayloadCompression
+
+	// if specified, message payloads are encrypted before they are sent or stored (after any compression)
+	KeyProvider KeyProvider
+
+	// FIFO messages without a deduplication id are given one derived from their payload. Use for FIFO
+	// queues that do not have content based deduplication enabled
+	ContentBasedDeduplication bool
 }
 
 // NewAwsSqs factory for our SQS interface
@@ -105,6 +474,61 @@ func NewAwsSqs(config AwsSqsConfig) (AWS_SQS, error) {
 	return aws, err
 }
 
+// NewInMemorySqs factory for an in-memory SQS interface, useful for testing. No AWS services are used,
+// the named queues are created empty and oversize messages use an in-memory payload store. Queue names ending
+// in .fifo are FIFO queues (with content based deduplication). Use NewAwsSqsAdminFor to manage further queues
+func NewInMemorySqs(queueNames ...string) AWS_SQS_Context {
+	return newInMemorySqs(queueNames)
+}
+
+// NewAwsSqsAdmin factory for our SQS administration interface
+func NewAwsSqsAdmin() (AWS_SQS_ADMIN, error) {
+	return newAwsSqsAdmin()
+}
+
+// NewAwsSqsAdminFor factory for an SQS administration interface that uses the same SQS service as the supplied
+// SQS interface (including an in-memory one)
+func NewAwsSqsAdminFor(sqs AWS_SQS) (AWS_SQS_ADMIN, error) {
+	return newAwsSqsAdminFor(sqs)
+}
+
+// NewS3PayloadStore factory for a payload store using S3, the default
+func NewS3PayloadStore() (PayloadStore, error) {
+	return newS3PayloadStore()
+}
+
+// NewFilesystemPayloadStore factory for a payload store using the local filesystem. Each bucket is
+// a directory beneath the supplied root directory, payloads are written in its .partial directory first
+func NewFilesystemPayloadStore(root string) (PayloadStore, error) {
+	return newFilesystemPayloadStore(root)
+}
+
+// NewMemoryPayloadStore factory for an in-memory payload store
+func NewMemoryPayloadStore() PayloadStore {
+	return newMemoryPayloadStore()
+}
+
+// NewLocalKeyProvider factory for a key provider that wraps data keys with the keys in a local keyfile. Each
+// line of the keyfile is a key id and a base64 encoded 256 bit key separated by whitespace. The first key wraps
+// new data keys and the others only unwrap them so keys can be rotated. Blank lines and comments (#) are ignored
+func NewLocalKeyProvider(keyfile string) (KeyProvider, error) {
+	kp, err := newLocalKeyProvider(keyfile)
+	if err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// NewSlogLogger factory for a logger that uses the supplied structured logger (the slog default if nil)
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return newSlogLogger(logger)
+}
+
+// NewSilentLogger factory for a logger that discards everything
+func NewSilentLogger() Logger {
+	return silentLogger{}
+}
+
 //
 // end of file
 //
@@ -585,7 +585,7 @@ func makeLargeMessages(count uint) []Message {
 func makeStandardMessage() Message {
 
 	attributes := make([]Attribute, 0, 1)
-	attributes = append(attributes, Attribute{"type", "text"})
+	attributes = append(attributes, Attribute{Name: "type", Value: "text"})
 	return Message{Attribs: attributes, Payload: []byte(fmt.Sprintf("this is message at %s", time.Now()))}
 }
 
@@ -594,8 +594,8 @@ func makeSmallMessage() Message {
 	payload := randomPayload(smallMessageSize)
 	hash := fmt.Sprintf("%x", md5.Sum([]byte(payload)))
 	attributes := make([]Attribute, 0, 2)
-	attributes = append(attributes, Attribute{"type", "text"})
-	attributes = append(attributes, Attribute{"hash", hash})
+	attributes = append(attributes, Attribute{Name: "type", Value: "text"})
+	attributes = append(attributes, Attribute{Name: "hash", Value: hash})
 	return Message{Attribs: attributes, Payload: payload}
 }
 
@@ -604,8 +604,8 @@ func makeLargeMessage() Message {
 	payload := randomPayload(largeMessageSize)
 	hash := fmt.Sprintf("%x", md5.Sum([]byte(payload)))
 	attributes := make([]Attribute, 0, 2)
-	attributes = append(attributes, Attribute{"type", "text"})
-	attributes = append(attributes, Attribute{"hash", hash})
+	attributes = append(attributes, Attribute{Name: "type", Value: "text"})
+	attributes = append(attributes, Attribute{Name: "hash", Value: hash})
 	return Message{Attribs: attributes, Payload: payload}
 }
 
@@ -628,7 +628,7 @@ func attributesSize(attribs Attributes) uint {
 	var padFactor = 3 // a guess at the padding for each string in the attribute set
 	sz := uint(0)
 	for _, a := range attribs {
-		sz += uint(len(a.Name) + len(a.Value) + (2 * padFactor))
+		sz += uint(len(a.Name) + len(AttributeDataTypeString) + len(a.Value) + (2 * padFactor))
 	}
 	return sz
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+)
+
+//
+// sweep the orphaned oversize message payloads from a message bucket, reporting them only unless -delete is specified
+//
+// payload-sweeper -bucket virgo4-ingest-staging-messages -queues virgo4-ingest-staging,virgo4-ingest-staging-dlq -minage 720h
+//
+
+func main() {
+
+	bucket := flag.String("bucket", "", "the message bucket name")
+	queues := flag.String("queues", "", "the names of the queues using the bucket, comma separated")
+	prefix := flag.String("prefix", "", "only sweep payloads whose keys begin with this prefix")
+	minAge := flag.Duration("minage", 0, "only sweep payloads older than this (the longest queue retention period if not specified)")
+	scan := flag.Bool("scan", false, "WARNING: receives every visible message to keep the payloads they reference, adding to their receive counts and hiding them from consumers")
+	remove := flag.Bool("delete", false, "delete the orphaned payloads rather than only reporting them")
+	flag.Parse()
+
+	if len(*bucket) == 0 || (len(*queues) == 0 && *minAge == 0) {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	sqs, err := awssqs.NewAwsSqs(awssqs.AwsSqsConfig{MessageBucketName: *bucket})
+	if err != nil {
+		log.Fatalf("ERROR: creating SQS interface (%s)", err.Error())
+	}
+
+	config := awssqs.PayloadSweeperConfig{Prefix: *prefix, MinimumAge: *minAge, DryRun: *remove == false, ScanQueues: *scan}
+	for _, name := range strings.Split(*queues, ",") {
+		if len(name) == 0 {
+			continue
+		}
+		handle, err := sqs.QueueHandle(name)
+		if err != nil {
+			log.Fatalf("ERROR: getting queue handle for %s (%s)", name, err.Error())
+		}
+		config.Queues = append(config.Queues, handle)
+	}
+
+	sweeper, err := awssqs.NewPayloadSweeper(sqs, config)
+	if err != nil {
+		log.Fatalf("ERROR: creating payload sweeper (%s)", err.Error())
+	}
+
+	report, err := sweeper.Sweep(context.Background())
+	for _, p := range report.Orphaned {
+		fmt.Printf("orphaned   %s (%d bytes, stored %s)\n", p.Key, p.Size, p.LastModified.Format(time.RFC3339))
+	}
+	for _, p := range report.Referenced {
+		fmt.Printf("referenced %s (%d bytes, stored %s)\n", p.Key, p.Size, p.LastModified.Format(time.RFC3339))
+	}
+	fmt.Printf("listed %d, orphaned %d, referenced %d, deleted %d\n", report.Listed, len(report.Orphaned), len(report.Referenced), report.Deleted)
+	if *remove == false {
+		fmt.Printf("dry run, specify -delete to delete the orphaned payloads\n")
+	}
+	if err != nil {
+		log.Fatalf("ERROR: sweeping payloads (%s)", err.Error())
+	}
+}
+
+//
+// end of file
+//
@@ -1,9 +1,22 @@
 module github.com/uvalib/virgo4-sqs-sdk/awssqs
 
-go 1.13
+go 1.21
 
 require (
 	github.com/aws/aws-sdk-go v1.51.13
 	github.com/google/uuid v1.6.0
-	github.com/uvalib/uva-aws-s3-sdk/uva-s3 v0.0.0-20240202155653-277e11cf83e3
+	github.com/klauspost/compress v1.17.9
+	github.com/prometheus/client_golang v1.19.1
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
@@ -0,0 +1,118 @@
+package prometheus
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+)
+
+// Observer an awssqs.Observer that maintains prometheus metrics, register it with a prometheus registry
+type Observer interface {
+	awssqs.Observer
+	prometheus.Collector
+}
+
+// NewObserver factory for an observer that maintains prometheus metrics labelled by queue name and
+// operation. The metric names are prefixed with the supplied namespace (if any)
+func NewObserver(namespace string) Observer {
+	return newObserver(namespace)
+}
+
+// the labels applied to each metric
+var prometheusLabels = []string{"queue", "operation"}
+
+// this is our prometheus observer implementation
+type prometheusObserver struct {
+	requests *prometheus.CounterVec   // operations made
+	errors   *prometheus.CounterVec   // operations that returned an error
+	latency  *prometheus.HistogramVec // operation latency
+	messages *prometheus.CounterVec   // messages (or payloads) included in operations
+	failures *prometheus.CounterVec   // messages (or payloads) that were not successful
+	bytes    *prometheus.CounterVec   // bytes included in operations
+	splits   *prometheus.CounterVec   // blocks split because they were too large
+}
+
+// factory for our prometheus observer
+func newObserver(namespace string) *prometheusObserver {
+
+	return &prometheusObserver{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "sqs", Name: "requests_total",
+			Help: "The number of SQS requests and oversize payload transfers.",
+		}, prometheusLabels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "sqs", Name: "request_errors_total",
+			Help: "The number of SQS requests and oversize payload transfers that returned an error.",
+		}, prometheusLabels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "sqs", Name: "request_duration_seconds",
+			Help:    "The latency of SQS requests and oversize payload transfers.",
+			Buckets: prometheus.DefBuckets,
+		}, prometheusLabels),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "sqs", Name: "messages_total",
+			Help: "The number of messages (or payloads) included in SQS requests and oversize payload transfers.",
+		}, prometheusLabels),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "sqs", Name: "message_failures_total",
+			Help: "The number of messages (or payloads) that were not successful.",
+		}, prometheusLabels),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "sqs", Name: "bytes_total",
+			Help: "The number of bytes included in SQS requests and oversize payload transfers.",
+		}, prometheusLabels),
+		splits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "sqs", Name: "block_splits_total",
+			Help: "The number of times a block of messages was split because it was too large.",
+		}, []string{"queue"}),
+	}
+}
+
+// Observe update the metrics for the observed operation
+func (p *prometheusObserver) Observe(event awssqs.ObserverEvent) {
+
+	queue := queueNameFromHandle(event.Queue)
+	if event.Operation == awssqs.OperationBlockSplit {
+		p.splits.WithLabelValues(queue).Inc()
+		return
+	}
+
+	operation := string(event.Operation)
+	p.requests.WithLabelValues(queue, operation).Inc()
+	if event.Err != nil {
+		p.errors.WithLabelValues(queue, operation).Inc()
+	}
+	p.latency.WithLabelValues(queue, operation).Observe(event.Latency.Seconds())
+	p.messages.WithLabelValues(queue, operation).Add(float64(event.Messages))
+	p.failures.WithLabelValues(queue, operation).Add(float64(event.Failures))
+	p.bytes.WithLabelValues(queue, operation).Add(float64(event.Bytes))
+}
+
+// Describe implements prometheus.Collector
+func (p *prometheusObserver) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range p.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (p *prometheusObserver) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range p.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (p *prometheusObserver) collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.requests, p.errors, p.latency, p.messages, p.failures, p.bytes, p.splits}
+}
+
+// the queue name is the last element of the queue handle (URL)
+func queueNameFromHandle(queue awssqs.QueueHandle) string {
+	q := string(queue)
+	return q[strings.LastIndex(q, "/")+1:]
+}
+
+//
+// end of file
+//
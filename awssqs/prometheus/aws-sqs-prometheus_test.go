@@ -0,0 +1,51 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uvalib/virgo4-sqs-sdk/awssqs"
+)
+
+var testQueueName = "virgo4-test-queue"
+
+//
+// prometheus observer behavior tests
+//
+
+func TestPrometheusObserver(t *testing.T) {
+
+	observer := newObserver("test")
+	queueHandle := awssqs.QueueHandle("https://sqs.us-east-1.amazonaws.com/000000000000/" + testQueueName)
+
+	observer.Observe(awssqs.ObserverEvent{Operation: awssqs.OperationSend, Queue: queueHandle, Messages: 10, Bytes: 1000, Failures: 2})
+	observer.Observe(awssqs.ObserverEvent{Operation: awssqs.OperationSend, Queue: queueHandle, Messages: 5, Bytes: 500, Err: awssqs.ErrBadQueueHandle})
+	observer.Observe(awssqs.ObserverEvent{Operation: awssqs.OperationBlockSplit, Queue: queueHandle, Messages: 10})
+
+	send := []string{testQueueName, string(awssqs.OperationSend)}
+	checks := []struct {
+		value    float64
+		expected float64
+	}{
+		{testutil.ToFloat64(observer.requests.WithLabelValues(send...)), 2},
+		{testutil.ToFloat64(observer.errors.WithLabelValues(send...)), 1},
+		{testutil.ToFloat64(observer.messages.WithLabelValues(send...)), 15},
+		{testutil.ToFloat64(observer.failures.WithLabelValues(send...)), 2},
+		{testutil.ToFloat64(observer.bytes.WithLabelValues(send...)), 1500},
+		{testutil.ToFloat64(observer.splits.WithLabelValues(testQueueName)), 1},
+	}
+	for _, c := range checks {
+		if c.value != c.expected {
+			t.Fatalf("Unexpected metric value. Expected %f, got %f\n", c.expected, c.value)
+		}
+	}
+
+	// all the metrics can be collected
+	if testutil.CollectAndCount(observer) != 7 {
+		t.Fatalf("Unexpected metric count %d\n", testutil.CollectAndCount(observer))
+	}
+}
+
+//
+// end of file
+//